@@ -0,0 +1,153 @@
+package main
+
+import "math/bits"
+
+// add returns a + b + carry truncated to width bytes and updates CF, PF, AF,
+// ZF, SF and OF the way the 8086 ADD and ADC instructions do.
+func (c *CPU) add(a, b, carry uint16, width uint8) uint16 {
+	mask, sign := uint32(0xFFFF), uint16(0x8000)
+	if width == 1 {
+		mask, sign = 0xFF, 0x80
+	}
+
+	sum := uint32(a) + uint32(b) + uint32(carry)
+	r := uint16(sum & mask)
+
+	c.SetFlag(FlagCF, sum > mask)
+	c.SetFlag(FlagAF, auxCarry(a, b, r))
+	c.setZSP(r, width)
+	c.SetFlag(FlagOF, (a^r)&(b^r)&sign != 0) // both operands differ in sign from the result
+
+	return r
+}
+
+// sub returns a - b - borrow truncated to width bytes and updates CF, PF, AF,
+// ZF, SF and OF the way the 8086 SUB and SBB instructions do. CF and AF are
+// set on a borrow.
+func (c *CPU) sub(a, b, borrow uint16, width uint8) uint16 {
+	mask, sign := uint32(0xFFFF), uint16(0x8000)
+	if width == 1 {
+		mask, sign = 0xFF, 0x80
+	}
+
+	diff := uint32(a) - uint32(b) - uint32(borrow)
+	r := uint16(diff & mask)
+
+	c.SetFlag(FlagCF, uint32(b)+uint32(borrow) > uint32(a))
+	c.SetFlag(FlagAF, auxCarry(a, b, r))
+	c.setZSP(r, width)
+	c.SetFlag(FlagOF, (a^b)&(a^r)&sign != 0) // operands differ in sign and the result took the sign of b
+
+	return r
+}
+
+// logicalFlags sets the flags after AND, OR, XOR and TEST: CF and OF are
+// cleared and ZF, SF and PF follow the result. AF is undefined and left alone.
+func (c *CPU) logicalFlags(r uint16, width uint8) {
+	c.SetFlag(FlagCF, false)
+	c.SetFlag(FlagOF, false)
+	c.setZSP(r, width)
+}
+
+// setZSP sets ZF when the result, masked to width bytes, is zero, SF from its
+// top bit and PF when it has even parity. Parity only ever looks at the low
+// 8 bits, even for word operations, matching the real chip.
+func (c *CPU) setZSP(r uint16, width uint8) {
+	sign := uint16(0x8000)
+	if width == 1 {
+		r &= 0xFF
+		sign = 0x80
+	}
+
+	c.SetFlag(FlagZF, r == 0)
+	c.SetFlag(FlagSF, r&sign != 0)
+	c.SetFlag(FlagPF, bits.OnesCount8(uint8(r))%2 == 0)
+}
+
+// auxCarry reports a carry or borrow between bit 3 and bit 4 of the result,
+// which is what AF tracks for the decimal adjust instructions. It only looks
+// at the low nibble, whatever the operand width. Bit 4 of a^b^r is bit 4 of
+// the sum without the carry that came out of bit 3.
+func auxCarry(a, b, r uint16) bool {
+	return (a^b^r)&0x10 != 0
+}
+
+// shiftRotate shifts or rotates *dst count times. op is the REG field of the
+// D0-D3 group: 0 ROL, 1 ROR, 2 RCL, 3 RCR, 4 SHL/SAL, 5 SHR and 7 SAR. w is
+// the W bit. CF gets the last bit shifted out and OF is only defined for
+// single bit shifts. The shifts also set ZF, SF and PF, the rotates don't.
+// A count of zero changes nothing, flags included.
+//
+// Unlike the 80186 and later, which mask the count to 5 bits, the 8086 uses
+// all of CL, so a shift by 32 clears a word and the rotates keep going round.
+// After a multi-bit shift or rotate OF is undefined and is left as it was,
+// AF is undefined after any shift and is never touched.
+func shiftRotate(c *CPU, op uint8, dst *uint16, count uint8, w uint8) {
+	if count == 0 {
+		return
+	}
+
+	bits, sign := uint8(8), uint16(0x80)
+	if w == 1 {
+		bits, sign = 16, 0x8000
+	}
+	mask := sign<<1 - 1 // wraps to 0xFFFF for words
+
+	v := *dst & mask
+	cf := c.GetFlag(FlagCF)
+	for i := uint8(0); i < count; i++ {
+		msb, lsb := v&sign != 0, v&1 != 0
+		switch op {
+		case 0: // ROL
+			v = v<<1 | v>>(bits-1)
+			cf = msb
+		case 1: // ROR
+			v = v>>1 | v<<(bits-1)
+			cf = lsb
+		case 2: // RCL
+			v <<= 1
+			if cf {
+				v |= 1
+			}
+			cf = msb
+		case 3: // RCR
+			v >>= 1
+			if cf {
+				v |= sign
+			}
+			cf = lsb
+		case 4: // SHL, SAL
+			v <<= 1
+			cf = msb
+		case 5: // SHR
+			v >>= 1
+			cf = lsb
+		case 7: // SAR, the sign bit is kept
+			v >>= 1
+			if msb {
+				v |= sign
+			}
+			cf = lsb
+		}
+		v &= mask
+	}
+
+	c.SetFlag(FlagCF, cf)
+	if count == 1 {
+		switch op {
+		case 0, 2, 4: // left: the new sign differs from the bit shifted out
+			c.SetFlag(FlagOF, (v&sign != 0) != cf)
+		case 1, 3: // right: the two top bits of the result differ
+			c.SetFlag(FlagOF, (v&sign != 0) != (v&(sign>>1) != 0))
+		case 5: // the sign bit of the original operand
+			c.SetFlag(FlagOF, *dst&sign != 0)
+		case 7:
+			c.SetFlag(FlagOF, false)
+		}
+	}
+	if op >= 4 {
+		c.setZSP(v, w+1)
+	}
+
+	*dst = v
+}
@@ -0,0 +1,538 @@
+package main
+
+import "testing"
+
+func TestAddAdc(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "ADD AL, 1 with AL=FF", code: []byte{0x04, 0x01, hlt},
+			setup: func(c *CPU) { c.AX = 0x00FF },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagCF | FlagZF | FlagAF | FlagPF, clear: FlagSF | FlagOF,
+		},
+		{
+			name: "ADD AL, 1 with AL=7F", code: []byte{0x04, 0x01, hlt},
+			setup: func(c *CPU) { c.AX = 0x007F },
+			regs:  map[string]uint16{"AX": 0x0080},
+			set:   FlagOF | FlagSF | FlagAF, clear: FlagCF | FlagZF | FlagPF,
+		},
+		{
+			name: "ADD AL, 1 with AL=0F", code: []byte{0x04, 0x01, hlt},
+			setup: func(c *CPU) { c.AX = 0x000F },
+			regs:  map[string]uint16{"AX": 0x0010},
+			set:   FlagAF, clear: FlagCF | FlagOF,
+		},
+		{
+			name: "ADD AL, 1 with AL=0E", code: []byte{0x04, 0x01, hlt},
+			setup: func(c *CPU) { c.AX = 0x000E },
+			regs:  map[string]uint16{"AX": 0x000F},
+			clear: FlagAF | FlagCF,
+		},
+		{
+			name: "ADD AX, BX", code: []byte{0x01, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x1234, 0x1111 },
+			regs:  map[string]uint16{"AX": 0x2345, "BX": 0x1111},
+			clear: FlagCF | FlagZF | FlagSF | FlagOF | FlagAF | FlagPF,
+		},
+		{
+			name: "ADD AX, 7FFF overflows", code: []byte{0x05, 0xFF, 0x7F, hlt},
+			setup: func(c *CPU) { c.AX = 0x0001 },
+			regs:  map[string]uint16{"AX": 0x8000},
+			set:   FlagOF | FlagSF, clear: FlagCF,
+		},
+		{
+			name: "ADD [BX], CX", code: []byte{0x01, 0x0F, hlt},
+			setup: func(c *CPU) { c.BX, c.CX = 0x0200, 0x0001; c.WriteMemWord(0, 0x0200, 0x00FF) },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x0100) },
+			set:   FlagAF, clear: FlagCF | FlagZF,
+		},
+		{
+			name: "ADD r/m16, sign extended imm8", code: []byte{0x83, 0xC0, 0xFF, hlt}, // add ax, -1
+			setup: func(c *CPU) { c.AX = 0x0005 },
+			regs:  map[string]uint16{"AX": 0x0004},
+			set:   FlagCF,
+		},
+		{
+			name: "ADC AL, 1 with CF set", code: []byte{0xF9, 0x14, 0x01, hlt},
+			setup: func(c *CPU) { c.AX = 0x0001 },
+			regs:  map[string]uint16{"AX": 0x0003},
+			clear: FlagCF,
+		},
+		{
+			name: "32-bit ADD then ADC",
+			code: []byte{
+				0x01, 0xD8, // add ax, bx
+				0x11, 0xCA, // adc dx, cx
+				hlt,
+			},
+			setup: func(c *CPU) { c.DX, c.AX, c.CX, c.BX = 0x0001, 0xFFFF, 0x0002, 0x0001 },
+			regs:  map[string]uint16{"DX": 0x0004, "AX": 0x0000},
+			clear: FlagCF,
+		},
+	})
+}
+
+func TestSubSbb(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "SUB AL, AL", code: []byte{0x28, 0xC0, hlt},
+			setup: func(c *CPU) { c.AX = 0x1205 },
+			regs:  map[string]uint16{"AX": 0x1200},
+			set:   FlagZF | FlagPF, clear: FlagCF | FlagSF | FlagOF,
+		},
+		{
+			name: "SUB AX, AX", code: []byte{0x29, 0xC0, hlt},
+			setup: func(c *CPU) { c.AX = 0xABCD },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagZF,
+		},
+		{
+			name: "SUB AL, 0 keeps AL", code: []byte{0x2C, 0x00, hlt},
+			setup: func(c *CPU) { c.AX = 0x0000 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagZF, clear: FlagCF,
+		},
+		{
+			name: "SUB BX, 1 borrows", code: []byte{0x83, 0xEB, 0x01, hlt},
+			setup: func(c *CPU) { c.BX = 0x0000 },
+			regs:  map[string]uint16{"BX": 0xFFFF},
+			set:   FlagCF | FlagSF | FlagAF | FlagPF, clear: FlagZF | FlagOF,
+		},
+		{
+			name: "SUB AL, BL, larger from smaller", code: []byte{0x28, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0001, 0x0002 },
+			regs:  map[string]uint16{"AX": 0x00FF},
+			set:   FlagCF | FlagSF,
+		},
+		{
+			name: "SUB AX, 1 from 8000", code: []byte{0x2D, 0x01, 0x00, hlt},
+			setup: func(c *CPU) { c.AX = 0x8000 },
+			regs:  map[string]uint16{"AX": 0x7FFF},
+			set:   FlagOF, clear: FlagCF | FlagSF,
+		},
+		{
+			name: "SUB [DI], AX", code: []byte{0x29, 0x05, hlt},
+			setup: func(c *CPU) { c.DI, c.AX = 0x0200, 0x0003; c.WriteMemWord(0, 0x0200, 0x0005) },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x0002) },
+		},
+		{
+			name: "32-bit SUB then SBB",
+			code: []byte{
+				0x29, 0xD8, // sub ax, bx
+				0x19, 0xCA, // sbb dx, cx
+				hlt,
+			},
+			setup: func(c *CPU) { c.DX, c.AX, c.CX, c.BX = 0x0001, 0x0000, 0x0000, 0x0001 },
+			regs:  map[string]uint16{"DX": 0x0000, "AX": 0xFFFF},
+			set:   FlagZF, clear: FlagCF,
+		},
+	})
+}
+
+func TestCMP(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "CMP AX, AX", code: []byte{0x39, 0xC0, hlt},
+			setup: func(c *CPU) { c.AX = 0x1234 },
+			regs:  map[string]uint16{"AX": 0x1234},
+			set:   FlagZF, clear: FlagCF | FlagSF,
+		},
+		{
+			name: "CMP AL, 10h with AL=5", code: []byte{0x3C, 0x10, hlt},
+			setup: func(c *CPU) { c.AX = 0x0005 },
+			regs:  map[string]uint16{"AX": 0x0005},
+			set:   FlagCF | FlagSF, clear: FlagZF,
+		},
+		{
+			name: "CMP AL, BL, greater", code: []byte{0x38, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0005, 0x0003 },
+			regs:  map[string]uint16{"AX": 0x0005, "BX": 0x0003},
+			clear: FlagCF | FlagZF | FlagSF,
+		},
+		{
+			name: "CMP [BX], imm8 leaves memory", code: []byte{0x80, 0x3F, 0x07, hlt},
+			setup: func(c *CPU) { c.BX = 0x0200; c.Memory[0x0200] = 0x07 },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 1, 0x07) },
+			set:   FlagZF,
+		},
+	})
+}
+
+func TestIncDec(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "INC AX from 7FFF", code: []byte{0xF9, 0x40, hlt}, // stc; inc ax
+			setup: func(c *CPU) { c.AX = 0x7FFF },
+			regs:  map[string]uint16{"AX": 0x8000},
+			set:   FlagOF | FlagSF | FlagCF,
+		},
+		{
+			name: "INC AX from FFFF", code: []byte{0x40, hlt},
+			setup: func(c *CPU) { c.AX = 0xFFFF },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagZF, clear: FlagCF | FlagOF,
+		},
+		{
+			name: "DEC AX to FFFF", code: []byte{0x48, hlt},
+			setup: func(c *CPU) { c.AX = 0x0000 },
+			regs:  map[string]uint16{"AX": 0xFFFF},
+			set:   FlagSF, clear: FlagCF | FlagZF,
+		},
+		{
+			name: "DEC AX keeps CF", code: []byte{0xF9, 0x48, hlt},
+			setup: func(c *CPU) { c.AX = 0x8000 },
+			regs:  map[string]uint16{"AX": 0x7FFF},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "INC BYTE [BX]", code: []byte{0xFE, 0x07, hlt},
+			setup: func(c *CPU) { c.BX = 0x0200; c.Memory[0x0200] = 0xFF; c.Memory[0x0201] = 0x11 },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x1100) },
+			set:   FlagZF,
+		},
+	})
+}
+
+func TestNegNot(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "NEG AL with AL=80", code: []byte{0xF6, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX = 0x0080 },
+			regs:  map[string]uint16{"AX": 0x0080},
+			set:   FlagOF | FlagCF | FlagSF,
+		},
+		{
+			name: "NEG AX with AX=1", code: []byte{0xF7, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX = 0x0001 },
+			regs:  map[string]uint16{"AX": 0xFFFF},
+			set:   FlagCF | FlagSF, clear: FlagOF,
+		},
+		{
+			name: "NEG AX with AX=0", code: []byte{0xF7, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX = 0x0000 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagZF, clear: FlagCF,
+		},
+		{
+			name: "NOT AX with all bits set", code: []byte{0xF9, 0xF7, 0xD0, hlt}, // stc; not ax
+			setup: func(c *CPU) { c.AX = 0xFFFF },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagCF, clear: FlagZF, // NOT changes no flags
+		},
+		{
+			name: "NOT AL", code: []byte{0xF6, 0xD0, hlt},
+			setup: func(c *CPU) { c.AX = 0x120F },
+			regs:  map[string]uint16{"AX": 0x12F0},
+		},
+	})
+}
+
+func TestLogical(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "XOR AX, AX", code: []byte{0xF9, 0x31, 0xC0, hlt}, // stc; xor ax, ax
+			setup: func(c *CPU) { c.AX = 0x1234 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagZF | FlagPF, clear: FlagCF | FlagOF | FlagSF,
+		},
+		{
+			name: "AND AL, 0Fh", code: []byte{0x24, 0x0F, hlt},
+			setup: func(c *CPU) { c.AX = 0xFF3C },
+			regs:  map[string]uint16{"AX": 0xFF0C},
+			set:   FlagPF, clear: FlagZF | FlagSF,
+		},
+		{
+			name: "OR [BX], CX", code: []byte{0x09, 0x0F, hlt},
+			setup: func(c *CPU) { c.BX, c.CX = 0x0200, 0x00F0; c.WriteMemWord(0, 0x0200, 0x0F00) },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x0FF0) },
+			clear: FlagZF | FlagSF,
+		},
+		{
+			name: "OR AX, 8000h", code: []byte{0x0D, 0x00, 0x80, hlt},
+			regs: map[string]uint16{"AX": 0x8000},
+			set:  FlagSF,
+		},
+		{
+			name: "TEST AL, 80h", code: []byte{0xA8, 0x80, hlt},
+			setup: func(c *CPU) { c.AX = 0x0081 },
+			regs:  map[string]uint16{"AX": 0x0081},
+			set:   FlagSF, clear: FlagZF,
+		},
+		{
+			name: "TEST AL, BL", code: []byte{0x84, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x000F, 0x00F0 },
+			regs:  map[string]uint16{"AX": 0x000F, "BX": 0x00F0},
+			set:   FlagZF,
+		},
+		{
+			name: "TEST BL, 1", code: []byte{0xF6, 0xC3, 0x01, hlt},
+			setup: func(c *CPU) { c.BX = 0x0002 },
+			regs:  map[string]uint16{"BX": 0x0002},
+			set:   FlagZF,
+		},
+		{
+			name: "TEST WORD [BX], 8000h", code: []byte{0xF7, 0x07, 0x00, 0x80, hlt},
+			setup: func(c *CPU) { c.BX = 0x0200; c.WriteMemWord(0, 0x0200, 0x8001) },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x8001) },
+			set:   FlagSF, clear: FlagZF | FlagCF | FlagOF,
+		},
+	})
+}
+
+func TestParity(t *testing.T) {
+	tests := []struct {
+		r    uint16
+		want bool
+	}{
+		{0x00, true},
+		{0x01, false},
+		{0x03, true},
+		{0x07, false},
+		{0x7F, false},
+		{0xFF, true},
+		{0x0100, true}, // only the low byte counts
+		{0x0180, false},
+	}
+
+	c := NewCPU()
+	for _, tt := range tests {
+		c.setZSP(tt.r, 2)
+		if got := c.GetFlag(FlagPF); got != tt.want {
+			t.Errorf("PF of %04X = %t, want %t", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "MUL BL", code: []byte{0xF6, 0xE3, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0003, 0x0004 },
+			regs:  map[string]uint16{"AX": 0x000C},
+			clear: FlagCF | FlagOF,
+		},
+		{
+			name: "MUL BL into AH", code: []byte{0xF6, 0xE3, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0080, 0x0002 },
+			regs:  map[string]uint16{"AX": 0x0100},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "MUL BX into DX", code: []byte{0xF7, 0xE3, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x1000, 0x0010 },
+			regs:  map[string]uint16{"DX": 0x0001, "AX": 0x0000},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "MUL BX in AX", code: []byte{0xF7, 0xE3, hlt},
+			setup: func(c *CPU) { c.AX, c.BX, c.DX = 0x0100, 0x0010, 0x1234 },
+			regs:  map[string]uint16{"DX": 0x0000, "AX": 0x1000},
+			clear: FlagCF | FlagOF,
+		},
+		{
+			name: "IMUL BL with BL=-1", code: []byte{0xF6, 0xEB, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0002, 0x00FF },
+			regs:  map[string]uint16{"AX": 0xFFFE},
+			clear: FlagCF | FlagOF,
+		},
+		{
+			name: "IMUL BL, -128 * -1", code: []byte{0xF6, 0xEB, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0080, 0x00FF },
+			regs:  map[string]uint16{"AX": 0x0080},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "IMUL BX, -1 * -1", code: []byte{0xF7, 0xEB, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0xFFFF, 0xFFFF },
+			regs:  map[string]uint16{"DX": 0x0000, "AX": 0x0001},
+			clear: FlagCF | FlagOF,
+		},
+	})
+}
+
+func TestDiv(t *testing.T) {
+	// divide errors go through INT 0, whose handler here sets CX
+	divideHandler := func(c *CPU) {
+		c.WriteMemWord(0, 0, 0x0200)
+		c.WriteMemWord(0, 2, 0x0000)
+		copy(c.Memory[0x0200:], []byte{0xB9, 0xAD, 0xDE, hlt}) // mov cx, DEADh; hlt
+	}
+
+	runProgramTests(t, []programTest{
+		{
+			name: "DIV BL", code: []byte{0xF6, 0xF3, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0007, 0x0002 },
+			regs:  map[string]uint16{"AX": 0x0103},
+		},
+		{
+			name: "DIV BX", code: []byte{0xF7, 0xF3, hlt},
+			setup: func(c *CPU) { c.DX, c.AX, c.BX = 0x0001, 0x0005, 0x0010 },
+			regs:  map[string]uint16{"AX": 0x1000, "DX": 0x0005},
+		},
+		{
+			name: "IDIV BL, -7 / 2", code: []byte{0xF6, 0xFB, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0xFFF9, 0x0002 },
+			regs:  map[string]uint16{"AX": 0xFFFD}, // -3 remainder -1
+		},
+		{
+			name: "IDIV BX, -7 / -2", code: []byte{0xF7, 0xFB, hlt},
+			setup: func(c *CPU) { c.DX, c.AX, c.BX = 0xFFFF, 0xFFF9, 0xFFFE },
+			regs:  map[string]uint16{"AX": 0x0003, "DX": 0xFFFF},
+		},
+		{
+			name: "DIV BL by zero", code: []byte{0xF6, 0xF3, hlt},
+			setup: func(c *CPU) { divideHandler(c); c.AX = 0x0007 },
+			regs:  map[string]uint16{"CX": 0xDEAD, "AX": 0x0007, "SP": 0xFFF8},
+		},
+		{
+			name: "DIV BL overflow", code: []byte{0xF6, 0xF3, hlt},
+			setup: func(c *CPU) { divideHandler(c); c.AX, c.BX = 0x1000, 0x0002 },
+			regs:  map[string]uint16{"CX": 0xDEAD, "AX": 0x1000},
+		},
+		{
+			name: "DIV BX by zero", code: []byte{0xF7, 0xF3, hlt},
+			setup: func(c *CPU) { divideHandler(c); c.AX = 0x0007 },
+			regs:  map[string]uint16{"CX": 0xDEAD},
+		},
+		{
+			name: "IDIV BL overflow, -32768 / -1", code: []byte{0xF6, 0xFB, hlt},
+			setup: func(c *CPU) { divideHandler(c); c.AX, c.BX = 0x8000, 0x00FF },
+			regs:  map[string]uint16{"CX": 0xDEAD},
+		},
+		{
+			name: "IDIV BX overflow", code: []byte{0xF7, 0xFB, hlt},
+			setup: func(c *CPU) { divideHandler(c); c.DX, c.AX, c.BX = 0x8000, 0x0000, 0xFFFF },
+			regs:  map[string]uint16{"CX": 0xDEAD},
+		},
+	})
+}
+
+func TestShiftRotate(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "SHL AX, 1", code: []byte{0xD1, 0xE0, hlt},
+			setup: func(c *CPU) { c.AX = 0x8001 },
+			regs:  map[string]uint16{"AX": 0x0002},
+			set:   FlagCF | FlagOF, clear: FlagZF | FlagSF,
+		},
+		{
+			name: "SHR AL, 1", code: []byte{0xD0, 0xE8, hlt},
+			setup: func(c *CPU) { c.AX = 0x0081 },
+			regs:  map[string]uint16{"AX": 0x0040},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "SAR AL, 1", code: []byte{0xD0, 0xF8, hlt},
+			setup: func(c *CPU) { c.AX = 0x0081 },
+			regs:  map[string]uint16{"AX": 0x00C0},
+			set:   FlagCF | FlagSF, clear: FlagOF,
+		},
+		{
+			name: "ROL AL, 1", code: []byte{0xD0, 0xC0, hlt},
+			setup: func(c *CPU) { c.AX = 0x0081 },
+			regs:  map[string]uint16{"AX": 0x0003},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "ROR AL, 1", code: []byte{0xD0, 0xC8, hlt},
+			setup: func(c *CPU) { c.AX = 0x0081 },
+			regs:  map[string]uint16{"AX": 0x00C0},
+			set:   FlagCF, clear: FlagOF,
+		},
+		{
+			name: "RCL AL, 1", code: []byte{0xD0, 0xD0, hlt},
+			setup: func(c *CPU) { c.AX = 0x0080 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "RCR AL, 1 with CF set", code: []byte{0xF9, 0xD0, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX = 0x0001 },
+			regs:  map[string]uint16{"AX": 0x0080},
+			set:   FlagCF | FlagOF,
+		},
+		{
+			name: "SHL AX, CL with CL=0", code: []byte{0xF9, 0xD3, 0xE0, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0x8000, 0x0000 },
+			regs:  map[string]uint16{"AX": 0x8000},
+			set:   FlagCF, clear: FlagZF, // nothing changes
+		},
+		{
+			name: "SHL AX, CL with CL=4", code: []byte{0xD3, 0xE0, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0x1234, 0x0004 },
+			regs:  map[string]uint16{"AX": 0x2340},
+			set:   FlagCF,
+		},
+		{
+			name: "SHL AX, CL with CL=16", code: []byte{0xD3, 0xE0, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0x0001, 0x0010 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagCF | FlagZF,
+		},
+		{
+			name: "SHR AX, CL with CL=16", code: []byte{0xD3, 0xE8, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0xFFFF, 0x0010 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagCF | FlagZF,
+		},
+		{
+			name: "SHL AX, CL with CL=32", code: []byte{0xD3, 0xE0, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0xFFFF, 0x0020 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagZF, clear: FlagCF,
+		},
+		{
+			name: "SAR AX, CL with CL=FF", code: []byte{0xD3, 0xF8, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0x8000, 0x00FF },
+			regs:  map[string]uint16{"AX": 0xFFFF},
+			set:   FlagCF | FlagSF,
+		},
+		{
+			name: "ROL then ROR AX, CL", code: []byte{0xD3, 0xC0, 0xD3, 0xC8, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0x1234, 0x0005 },
+			regs:  map[string]uint16{"AX": 0x1234},
+		},
+		{
+			name: "ROL AX, CL with CL=4", code: []byte{0xD3, 0xC0, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0x1234, 0x0004 },
+			regs:  map[string]uint16{"AX": 0x2341},
+			set:   FlagCF,
+		},
+		{
+			name: "RCL AX, CL with CL=17", code: []byte{0xD3, 0xD0, hlt}, // 17 bits go all the way round
+			setup: func(c *CPU) { c.AX, c.CX = 0x8421, 0x0011 },
+			regs:  map[string]uint16{"AX": 0x8421},
+			clear: FlagCF,
+		},
+		{
+			name: "SHL WORD [BX], CL", code: []byte{0xD3, 0x27, hlt},
+			setup: func(c *CPU) { c.BX, c.CX = 0x0200, 0x0003; c.WriteMemWord(0, 0x0200, 0x0101) },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x0808) },
+		},
+	})
+}
+
+func TestSignExtend(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "CBW with AL=80", code: []byte{0x98, hlt},
+			setup: func(c *CPU) { c.AX, c.DX = 0x0080, 0x1234 },
+			regs:  map[string]uint16{"AX": 0xFF80, "DX": 0x1234},
+		},
+		{
+			name: "CBW with AL=7F", code: []byte{0x98, hlt},
+			setup: func(c *CPU) { c.AX = 0xFF7F },
+			regs:  map[string]uint16{"AX": 0x007F},
+		},
+		{
+			name: "CWD with AX=8000", code: []byte{0x99, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x8000, 0x5555 },
+			regs:  map[string]uint16{"AX": 0x8000, "DX": 0xFFFF, "BX": 0x5555},
+		},
+		{
+			name: "CWD with AX=7FFF", code: []byte{0x99, hlt},
+			setup: func(c *CPU) { c.AX, c.DX = 0x7FFF, 0xFFFF },
+			regs:  map[string]uint16{"AX": 0x7FFF, "DX": 0x0000},
+		},
+	})
+}
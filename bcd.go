@@ -0,0 +1,89 @@
+package main
+
+// The decimal adjust instructions fix up AL after arithmetic on BCD digits.
+// Unpacked BCD keeps one digit per byte (AAA, AAS, AAM and AAD), packed BCD
+// two digits per byte (DAA and DAS). Flags the manual lists as undefined are
+// left alone.
+
+// aaa adjusts AL after adding two unpacked BCD digits, carrying into AH.
+func (c *CPU) aaa() {
+	adjust := getAL(c)&0x0F > 9 || c.GetFlag(FlagAF)
+	if adjust {
+		// on the 8086 the +6 does not carry from AL into AH
+		setAL(c, getAL(c)+6)
+		setAH(c, getAH(c)+1)
+	}
+	setAL(c, getAL(c)&0x0F)
+	c.SetFlag(FlagAF, adjust)
+	c.SetFlag(FlagCF, adjust)
+}
+
+// aas adjusts AL after subtracting two unpacked BCD digits, borrowing from
+// AH.
+func (c *CPU) aas() {
+	adjust := getAL(c)&0x0F > 9 || c.GetFlag(FlagAF)
+	if adjust {
+		setAL(c, getAL(c)-6)
+		setAH(c, getAH(c)-1)
+	}
+	setAL(c, getAL(c)&0x0F)
+	c.SetFlag(FlagAF, adjust)
+	c.SetFlag(FlagCF, adjust)
+}
+
+// daa adjusts AL after adding two packed BCD numbers.
+func (c *CPU) daa() {
+	al, oldCF := getAL(c), c.GetFlag(FlagCF)
+	cf := oldCF
+
+	c.SetFlag(FlagAF, al&0x0F > 9 || c.GetFlag(FlagAF))
+	if c.GetFlag(FlagAF) {
+		setAL(c, getAL(c)+6)
+		cf = cf || al > 0xFF-6
+	}
+	if al > 0x99 || oldCF {
+		setAL(c, getAL(c)+0x60)
+		cf = true
+	}
+	c.SetFlag(FlagCF, cf)
+	c.setZSP(uint16(getAL(c)), 1)
+}
+
+// das adjusts AL after subtracting two packed BCD numbers.
+func (c *CPU) das() {
+	al, oldCF := getAL(c), c.GetFlag(FlagCF)
+	cf := oldCF
+
+	c.SetFlag(FlagAF, al&0x0F > 9 || c.GetFlag(FlagAF))
+	if c.GetFlag(FlagAF) {
+		setAL(c, getAL(c)-6)
+		cf = cf || al < 6
+	}
+	if al > 0x99 || oldCF {
+		setAL(c, getAL(c)-0x60)
+		cf = true
+	}
+	c.SetFlag(FlagCF, cf)
+	c.setZSP(uint16(getAL(c)), 1)
+}
+
+// aam splits AL into two unpacked digits, AH = AL / base and AL = AL % base.
+// base is 10 in the usual encoding, D4 0A. A base of zero is a divide error.
+func (c *CPU) aam(base uint8) error {
+	if base == 0 {
+		return &DivideError{Dividend: uint32(getAL(c)), Divisor: 0}
+	}
+	al := getAL(c)
+	setAH(c, al/base)
+	setAL(c, al%base)
+	c.setZSP(uint16(getAL(c)), 1)
+	return nil
+}
+
+// aad joins the unpacked digits in AH and AL into a binary AL before a
+// division, AL = AH * base + AL and AH = 0.
+func (c *CPU) aad(base uint8) {
+	setAL(c, getAH(c)*base+getAL(c))
+	setAH(c, 0)
+	c.setZSP(uint16(getAL(c)), 1)
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestBCD(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "ADD then AAA, 8 + 9", code: []byte{0x00, 0xD8, 0x37, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0008, 0x0009 },
+			regs:  map[string]uint16{"AX": 0x0107},
+			set:   FlagCF | FlagAF,
+		},
+		{
+			name: "ADD then AAA, 2 + 3", code: []byte{0x00, 0xD8, 0x37, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0002, 0x0003 },
+			regs:  map[string]uint16{"AX": 0x0005},
+			clear: FlagCF | FlagAF,
+		},
+		{
+			name: "SUB then AAS, 23 - 8", code: []byte{0x28, 0xD8, 0x3F, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0203, 0x0008 },
+			regs:  map[string]uint16{"AX": 0x0105},
+			set:   FlagCF | FlagAF,
+		},
+		{
+			name: "ADD then DAA, 79 + 35", code: []byte{0x04, 0x35, 0x27, hlt},
+			setup: func(c *CPU) { c.AX = 0x0079 },
+			regs:  map[string]uint16{"AX": 0x0014},
+			set:   FlagCF | FlagAF,
+		},
+		{
+			name: "ADD then DAA, 38 + 45", code: []byte{0x04, 0x45, 0x27, hlt},
+			setup: func(c *CPU) { c.AX = 0x0038 },
+			regs:  map[string]uint16{"AX": 0x0083},
+			set:   FlagAF | FlagSF, clear: FlagCF,
+		},
+		{
+			name: "SUB then DAS, 35 - 47", code: []byte{0x2C, 0x47, 0x2F, hlt},
+			setup: func(c *CPU) { c.AX = 0x0035 },
+			regs:  map[string]uint16{"AX": 0x0088},
+			set:   FlagCF | FlagAF,
+		},
+		{
+			name: "SUB then DAS, 86 - 07", code: []byte{0x2C, 0x07, 0x2F, hlt},
+			setup: func(c *CPU) { c.AX = 0x0086 },
+			regs:  map[string]uint16{"AX": 0x0079},
+			set:   FlagAF, clear: FlagCF,
+		},
+		{
+			name: "MUL then AAM, 7 * 9", code: []byte{0xF6, 0xE3, 0xD4, 0x0A, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0007, 0x0009 },
+			regs:  map[string]uint16{"AX": 0x0603},
+			clear: FlagZF | FlagSF,
+		},
+		{
+			name: "AAM base 16", code: []byte{0xD4, 0x10, hlt},
+			setup: func(c *CPU) { c.AX = 0x003F },
+			regs:  map[string]uint16{"AX": 0x030F},
+		},
+		{
+			name: "AAD then DIV, 73 / 2", code: []byte{0xD5, 0x0A, 0xF6, 0xF3, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x0703, 0x0002 },
+			regs:  map[string]uint16{"AX": 0x0124}, // 36 remainder 1
+		},
+		{
+			name: "AAM base 0", code: []byte{0xD4, 0x00, hlt},
+			setup: func(c *CPU) {
+				c.WriteMemWord(0, 0, 0x0200) // INT 0 goes to 0000:0200
+				copy(c.Memory[0x0200:], []byte{0xB9, 0xAD, 0xDE, hlt})
+				c.AX = 0x0042
+			},
+			regs: map[string]uint16{"CX": 0xDEAD, "AX": 0x0042},
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// UnsupportedBIOSFunction is returned by Run when a program calls a BIOS
+// service that is not emulated.
+type UnsupportedBIOSFunction struct {
+	Interrupt uint8
+	Function  uint8 // the AH value
+}
+
+func (e *UnsupportedBIOSFunction) Error() string {
+	return fmt.Sprintf("unsupported BIOS function: INT %02Xh AH=%02Xh", e.Interrupt, e.Function)
+}
+
+// NewCPUWithBIOS returns a CPU with the BIOS services installed as interrupt
+// handlers.
+func NewCPUWithBIOS() *CPU {
+	c := NewCPU()
+	c.RegisterInterruptHandler(0x10, biosVideo)
+	return c
+}
+
+// SetOutput sets where the BIOS and DOS services write characters to, it is
+// os.Stdout by default.
+func (c *CPU) SetOutput(w io.Writer) {
+	c.output = w
+}
+
+// SetInput sets where the DOS services read characters from, it is os.Stdin
+// by default.
+func (c *CPU) SetInput(r io.Reader) {
+	c.input = r
+}
+
+// biosVideo is INT 10h. Only teletype output, AH=0Eh, is supported: it
+// writes the character in AL.
+func biosVideo(c *CPU) error {
+	switch getAH(c) {
+	case 0x0E:
+		_, err := c.output.Write([]byte{getAL(c)})
+		return err
+	}
+	return &UnsupportedBIOSFunction{Interrupt: 0x10, Function: getAH(c)}
+}
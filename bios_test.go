@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBIOSTeletype(t *testing.T) {
+	c := NewCPUWithBIOS()
+	c.ResetRegisters()
+	err := c.LoadProgramFromBytes([]byte{
+		0xB4, 0x0E, // mov ah, 0Eh
+		0xB0, 'O', // mov al, 'O'
+		0xCD, 0x10, // int 10h
+		0xB0, 'K', // mov al, 'K'
+		0xCD, 0x10, // int 10h
+		hlt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	c.SetOutput(&out)
+
+	err = c.Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "OK" {
+		t.Errorf("output %q, want %q", out.String(), "OK")
+	}
+}
+
+func TestBIOSUnsupported(t *testing.T) {
+	c := NewCPUWithBIOS()
+	c.ResetRegisters()
+	err := c.LoadProgramFromBytes([]byte{0xB4, 0x00, 0xCD, 0x10, hlt}) // mov ah, 0; int 10h
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Run(100)
+	var unsupported *UnsupportedBIOSFunction
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Run = %v, want UnsupportedBIOSFunction", err)
+	}
+	if unsupported.Interrupt != 0x10 || unsupported.Function != 0x00 {
+		t.Errorf("got %+v, want INT 10h AH=00h", *unsupported)
+	}
+}
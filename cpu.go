@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -26,7 +28,44 @@ type CPU struct {
 
 	Flag uint16
 
-	programSize int
+	Halted   bool  // set by HLT, Run stops here
+	ExitCode uint8 // return code a DOS program terminated with
+	Verbose  bool  // print each instruction as it is decoded
+
+	Cycles       uint64 // clocks of every instruction Step has run
+	Instructions uint64 // number of instructions Step has run
+
+	// MaxInstructions stops Run with MaxInstructionsExceeded once
+	// Instructions reaches it, zero means no limit.
+	MaxInstructions uint64
+
+	// segmentOverride points at the segment register named by a segment
+	// override prefix while that instruction runs, nil means use the
+	// default segment.
+	segmentOverride *uint16
+
+	// InterruptHandlers service interrupts in Go, by vector, see
+	// RegisterInterruptHandler.
+	InterruptHandlers map[uint8]func(*CPU) error
+
+	hooks map[uint8][]func(*CPU, Instruction) // by opcode
+
+	breakpoints    map[uint16]struct{} // IP values Run stops at
+	lastBreakpoint *BreakpointHit      // where Run last stopped, until Step moves on
+
+	readWatchpoints  []watchpoint
+	writeWatchpoints []watchpoint
+	watchHit         *WatchpointHit // first watchpoint hit by the current instruction
+
+	output io.Writer // character output of the BIOS and DOS services
+	input  io.Reader // character input of the DOS services
+	trace  io.Writer // where EnableTrace sends the trace, nil when off
+
+	PortIn  PortIn  // called by IN
+	PortOut PortOut // called by OUT
+
+	programStart int // physical address the program was loaded at
+	programSize  int
 
 	// 1MB of memory
 	Memory [1048576]byte
@@ -109,11 +148,12 @@ func (c *CPU) PrintRegisters() {
 	fmt.Printf("ES: %04X %016b\n", c.ES, c.ES)
 	fmt.Printf("SS: %04X %016b\n", c.SS, c.SS)
 	fmt.Printf("IP: %04X %016b\n", c.IP, c.IP)
-	fmt.Printf("FL: %04X %016b\n", c.FL, c.FL)
+	fmt.Printf("FL: %04X %016b %s\n", c.FL, c.FL, c.flagsString())
 	fmt.Printf("SP: %04X %016b\n", c.SP, c.SP)
 	fmt.Printf("PC: %04X %016b\n", c.PC, c.PC)
 
 	fmt.Printf("Flag: %04X %016b\n", c.Flag, c.Flag)
+	fmt.Printf("Halted: %t\n", c.Halted)
 
 	c.PrintMemory()
 }
@@ -122,16 +162,17 @@ func (c *CPU) PrintMemory() {
 	fmt.Printf("Memory:\n")
 
 	// print binary
-	for i := 0; i < c.programSize; i++ {
+	end := c.programStart + c.programSize
+	for i := c.programStart; i < end; i++ {
 		fmt.Printf("%08b\n", c.Memory[i])
 	}
 
 	fmt.Printf("\n")
 
-	for i := 0; i < c.programSize; i += 16 {
+	for i := c.programStart; i < end; i += 16 {
 
 		// Print Hex
-		fmt.Printf("%04X: ", i)
+		fmt.Printf("%05X: ", i)
 		for j := 0; j < 16; j++ {
 			fmt.Printf("%02X ", c.Memory[i+j])
 		}
@@ -151,17 +192,47 @@ func (c *CPU) PrintMemory() {
 	}
 }
 
-type Instruction struct {
-	// mnemonic
-	// destination
-	// source
-	// code   -> 6 bits
-	// D      -> 1 bit
-	// W      -> 1 bit
-	// Mod    -> 2 bits
-	// Reg    -> 3 bits
-	// R/M    -> 3 bits
+// OperandKind tells where an instruction operand lives.
+type OperandKind uint8
+
+const (
+	OperandNone OperandKind = iota
+	OperandReg              // general register, selected by Reg and the width
+	OperandSeg              // segment register, selected by Reg
+	OperandMem              // memory, addressed by the mod/rm fields
+	OperandImm              // immediate data following the opcode
+	OperandRel              // jump target, IP of the next instruction plus Disp
+	OperandFar              // far pointer immediate, Seg:Imm
+)
 
+type Operand struct {
+	Kind OperandKind
+	Reg  uint8 // register number for OperandReg and OperandSeg
+}
+
+// accumulator is AL or AX, depending on the instruction width.
+var accumulator = Operand{Kind: OperandReg, Reg: 0}
+
+type Instruction struct {
+	Mnemonic string
+	Dst      Operand // destination
+	Src      Operand // source
+	Width    uint8   // operand size in bytes, 1 or 2
+	Cycles   uint8   // clocks from the manual, without the EA calculation
+
+	Opcode uint8  // first byte of the encoding
+	D      uint8  // 1 bit, REG is the destination
+	W      uint8  // 1 bit, word operation
+	Mod    uint8  // 2 bits
+	Reg    uint8  // 3 bits
+	RM     uint8  // 3 bits
+	Disp   int16  // displacement, sign extended when 8 bits
+	Imm    uint16 // immediate data
+	Seg    uint16 // segment of a far pointer immediate
+	Size   uint8  // total size of the encoding in bytes, prefixes included
+
+	SegOverride uint8 // segment override prefix byte, zero if there is none
+	REP         uint8 // repeat prefix, prefixREP or prefixREPNE, zero if none
 }
 
 /*
@@ -232,55 +303,626 @@ R/M (Register/Memory) Field Encoding
 
 var (
 	mnemonics = map[uint8]string{
-		0b100010: "MOV",
+		0x00: "ADD", 0x01: "ADD", 0x02: "ADD", 0x03: "ADD", 0x04: "ADD", 0x05: "ADD",
+		0x08: "OR", 0x09: "OR", 0x0A: "OR", 0x0B: "OR", 0x0C: "OR", 0x0D: "OR",
+		0x10: "ADC", 0x11: "ADC", 0x12: "ADC", 0x13: "ADC", 0x14: "ADC", 0x15: "ADC",
+		0x18: "SBB", 0x19: "SBB", 0x1A: "SBB", 0x1B: "SBB", 0x1C: "SBB", 0x1D: "SBB",
+		0x20: "AND", 0x21: "AND", 0x22: "AND", 0x23: "AND", 0x24: "AND", 0x25: "AND", 0x27: "DAA",
+		0x28: "SUB", 0x29: "SUB", 0x2A: "SUB", 0x2B: "SUB", 0x2C: "SUB", 0x2D: "SUB", 0x2F: "DAS",
+		0x30: "XOR", 0x31: "XOR", 0x32: "XOR", 0x33: "XOR", 0x34: "XOR", 0x35: "XOR", 0x37: "AAA",
+		0x38: "CMP", 0x39: "CMP", 0x3A: "CMP", 0x3B: "CMP", 0x3C: "CMP", 0x3D: "CMP", 0x3F: "AAS",
+		0x40: "INC", 0x41: "INC", 0x42: "INC", 0x43: "INC",
+		0x44: "INC", 0x45: "INC", 0x46: "INC", 0x47: "INC",
+		0x48: "DEC", 0x49: "DEC", 0x4A: "DEC", 0x4B: "DEC",
+		0x4C: "DEC", 0x4D: "DEC", 0x4E: "DEC", 0x4F: "DEC",
+		0x06: "PUSH", 0x0E: "PUSH", 0x16: "PUSH", 0x1E: "PUSH",
+		0x07: "POP", 0x0F: "POP", 0x17: "POP", 0x1F: "POP",
+		0x50: "PUSH", 0x51: "PUSH", 0x52: "PUSH", 0x53: "PUSH",
+		0x54: "PUSH", 0x55: "PUSH", 0x56: "PUSH", 0x57: "PUSH",
+		0x58: "POP", 0x59: "POP", 0x5A: "POP", 0x5B: "POP",
+		0x5C: "POP", 0x5D: "POP", 0x5E: "POP", 0x5F: "POP",
+		0x68: "PUSH",
+		0x70: "JO", 0x71: "JNO", 0x72: "JB", 0x73: "JAE",
+		0x74: "JE", 0x75: "JNE", 0x76: "JBE", 0x77: "JA",
+		0x78: "JS", 0x79: "JNS", 0x7A: "JP", 0x7B: "JNP",
+		0x7C: "JL", 0x7D: "JGE", 0x7E: "JLE", 0x7F: "JG",
+		0x84: "TEST", 0x85: "TEST", 0xA8: "TEST", 0xA9: "TEST",
+		0x88: "MOV", 0x89: "MOV", 0x8A: "MOV", 0x8B: "MOV",
+		0x8C: "MOV", 0x8D: "LEA", 0x8E: "MOV",
+		0x86: "XCHG", 0x87: "XCHG",
+		0x90: "NOP", 0x91: "XCHG", 0x92: "XCHG", 0x93: "XCHG",
+		0x94: "XCHG", 0x95: "XCHG", 0x96: "XCHG", 0x97: "XCHG",
+		0x9A: "CALL",
+		0x98: "CBW", 0x99: "CWD",
+		0x9C: "PUSHF", 0x9D: "POPF", 0x9E: "SAHF", 0x9F: "LAHF",
+		0xA0: "MOV", 0xA1: "MOV", 0xA2: "MOV", 0xA3: "MOV",
+		0xA4: "MOVSB", 0xA5: "MOVSW", 0xA6: "CMPSB", 0xA7: "CMPSW",
+		0xAA: "STOSB", 0xAB: "STOSW", 0xAC: "LODSB", 0xAD: "LODSW",
+		0xAE: "SCASB", 0xAF: "SCASW",
+		0xB0: "MOV", 0xB1: "MOV", 0xB2: "MOV", 0xB3: "MOV",
+		0xB4: "MOV", 0xB5: "MOV", 0xB6: "MOV", 0xB7: "MOV",
+		0xB8: "MOV", 0xB9: "MOV", 0xBA: "MOV", 0xBB: "MOV",
+		0xBC: "MOV", 0xBD: "MOV", 0xBE: "MOV", 0xBF: "MOV",
+		0xC2: "RET", 0xC3: "RET", 0xC4: "LES", 0xC5: "LDS", 0xCA: "RETF", 0xCB: "RETF",
+		0xCC: "INT", 0xCD: "INT", 0xCE: "INTO", 0xCF: "IRET",
+		0xD4: "AAM", 0xD5: "AAD", 0xD7: "XLAT",
+		0xE0: "LOOPNE", 0xE1: "LOOPE", 0xE2: "LOOP", 0xE3: "JCXZ",
+		0xE4: "IN", 0xE5: "IN", 0xE6: "OUT", 0xE7: "OUT",
+		0xE8: "CALL",
+		0xE9: "JMP", 0xEA: "JMP", 0xEB: "JMP",
+		0xEC: "IN", 0xED: "IN", 0xEE: "OUT", 0xEF: "OUT",
+		0xF4: "HLT", 0xF5: "CMC",
+		0xF8: "CLC", 0xF9: "STC", 0xFA: "CLI", 0xFB: "STI", 0xFC: "CLD", 0xFD: "STD",
+	}
+
+	// groupMnemonics names the opcodes that keep the operation in the REG
+	// field of the mod reg r/m byte.
+	groupMnemonics = map[uint8][8]string{
+		0x80: {0: "ADD", 1: "OR", 2: "ADC", 3: "SBB", 4: "AND", 5: "SUB", 6: "XOR", 7: "CMP"},
+		0x81: {0: "ADD", 1: "OR", 2: "ADC", 3: "SBB", 4: "AND", 5: "SUB", 6: "XOR", 7: "CMP"},
+		0x82: {0: "ADD", 1: "OR", 2: "ADC", 3: "SBB", 4: "AND", 5: "SUB", 6: "XOR", 7: "CMP"},
+		0x83: {0: "ADD", 1: "OR", 2: "ADC", 3: "SBB", 4: "AND", 5: "SUB", 6: "XOR", 7: "CMP"},
+		0x8F: {0: "POP"},
+		0xC6: {0: "MOV"},
+		0xD0: {0: "ROL", 1: "ROR", 2: "RCL", 3: "RCR", 4: "SHL", 5: "SHR", 7: "SAR"},
+		0xD1: {0: "ROL", 1: "ROR", 2: "RCL", 3: "RCR", 4: "SHL", 5: "SHR", 7: "SAR"},
+		0xD2: {0: "ROL", 1: "ROR", 2: "RCL", 3: "RCR", 4: "SHL", 5: "SHR", 7: "SAR"},
+		0xD3: {0: "ROL", 1: "ROR", 2: "RCL", 3: "RCR", 4: "SHL", 5: "SHR", 7: "SAR"},
+		0xC7: {0: "MOV"},
+		0xF6: {0: "TEST", 2: "NOT", 3: "NEG", 4: "MUL", 5: "IMUL", 6: "DIV", 7: "IDIV"},
+		0xF7: {0: "TEST", 2: "NOT", 3: "NEG", 4: "MUL", 5: "IMUL", 6: "DIV", 7: "IDIV"},
+		0xFE: {0: "INC", 1: "DEC"},
+		0xFF: {0: "INC", 1: "DEC", 2: "CALL", 3: "CALL", 4: "JMP", 5: "JMP", 6: "PUSH"},
 	}
 )
 
-func (c *CPU) calcLen(opcode uint8, d uint8, w uint8, mod uint8, rm uint8) (uint8, error) {
-	length := uint8(0)
-	switch opcode {
-	case 0b100010:
-		length = 1
-		if w == 1 {
-			length = 2
+// calcLen returns the total length in bytes of an instruction: the opcode,
+// the mod reg r/m byte and its displacement, and any immediate data. mod, reg
+// and rm are only looked at for opcodes that take a mod reg r/m byte, reg
+// matters for group 3 where only TEST has an immediate.
+func calcLen(opcode uint8, mod uint8, reg uint8, rm uint8) (uint8, error) {
+	length := uint8(1)
+	switch {
+	case opcode < 0x40 && opcode&0b111 <= 0b011, // ALU r/m, reg
+		opcode == 0x84, opcode == 0x85, // TEST r/m, reg
+		opcode >= 0x88 && opcode <= 0x8B, // MOV r/m, reg
+		opcode == 0x8D,                   // LEA
+		opcode == 0xC4, opcode == 0xC5,   // LES and LDS
+		opcode == 0x86, opcode == 0x87, // XCHG r/m, reg
+		opcode == 0x8C, opcode == 0x8E: // MOV with a segment register
+		length += 1 + dispLen(mod, rm)
+	case opcode >= 0x40 && opcode <= 0x4F, // INC/DEC reg16
+		opcode >= 0x90 && opcode <= 0x97,       // NOP and XCHG AX, reg16
+		opcode >= 0x50 && opcode <= 0x5F,       // PUSH/POP reg16
+		opcode < 0x20 && opcode&0b110 == 0b110, // PUSH/POP sreg
+		opcode == 0x98, opcode == 0x99,         // CBW and CWD
+		opcode < 0x40 && opcode&0b111 == 0b111, // DAA, DAS, AAA and AAS
+		opcode >= 0x9C && opcode <= 0x9F,       // PUSHF, POPF, SAHF and LAHF
+		opcode >= 0xA4 && opcode <= 0xA7,       // MOVS and CMPS
+		opcode >= 0xAA && opcode <= 0xAF,       // STOS, LODS and SCAS
+		opcode == 0xC3, opcode == 0xCB,         // RET and RETF
+		opcode == 0xCC, opcode == 0xCE, opcode == 0xCF, // INT 3, INTO and IRET
+		opcode == 0xD7,                   // XLAT
+		opcode >= 0xEC && opcode <= 0xEF, // IN and OUT with the port in DX
+		opcode == 0xF4, opcode == 0xF5,   // HLT and CMC
+		opcode >= 0xF8 && opcode <= 0xFD: // CLC, STC, CLI, STI, CLD and STD
+	case opcode == 0xFE, opcode == 0xFF: // group 4 and 5
+		length += 1 + dispLen(mod, rm)
+	case opcode >= 0x70 && opcode <= 0x7F, // Jcc rel8
+		opcode >= 0xE0 && opcode <= 0xE3, // LOOPcc and JCXZ rel8
+		opcode == 0xEB,                   // JMP rel8
+		opcode == 0xCD,                   // INT imm8
+		opcode == 0xD4, opcode == 0xD5,   // AAM and AAD imm8
+		opcode >= 0xE4 && opcode <= 0xE7: // IN and OUT imm8
+		length++
+	case opcode == 0x8F: // POP r/m16
+		length += 1 + dispLen(mod, rm)
+	case opcode == 0x68, // PUSH imm16
+		opcode == 0xE8, opcode == 0xE9, // CALL and JMP rel16
+		opcode == 0xC2, opcode == 0xCA: // RET and RETF imm16
+		length += 2
+	case opcode == 0x9A, opcode == 0xEA: // CALL and JMP ptr16:16
+		length += 4
+	case opcode >= 0x80 && opcode <= 0x83: // group 1, r/m, imm
+		length += 1 + dispLen(mod, rm) + 1
+		if opcode == 0x81 {
+			length++
+		}
+	case opcode == 0xC6, opcode == 0xC7: // MOV r/m, imm
+		length += 1 + dispLen(mod, rm) + 1 + opcode&1
+	case opcode < 0x40 && opcode&0b111 <= 0b101: // ALU accumulator, imm
+		length += 1 + opcode&1
+	case opcode >= 0xA0 && opcode <= 0xA3: // MOV accumulator, [addr]
+		length += 2
+	case opcode >= 0xB0 && opcode <= 0xBF: // MOV reg, imm
+		length += 1 + (opcode&0b1000)>>3
+	case opcode == 0xF6, opcode == 0xF7: // group 3
+		length += 1 + dispLen(mod, rm)
+		if reg == 0 { // TEST r/m, imm
+			length += 1 + opcode&1
 		}
+	case opcode == 0xA8, opcode == 0xA9: // TEST accumulator, imm
+		length += 1 + opcode&1
+	case opcode >= 0xD0 && opcode <= 0xD3: // group 2, shifts and rotates
+		length += 1 + dispLen(mod, rm)
 	default:
-		return 0, fmt.Errorf("invalid opcode: %d", opcode)
-
+		return 0, fmt.Errorf("invalid opcode: %02X", opcode)
 	}
 
 	return length, nil
 }
 
-func (c *CPU) DecodeInatruction() (Instruction, error) {
+// decoder reads the bytes of a single instruction, fetch returns the byte at
+// offset n from the start of the instruction.
+type decoder struct {
+	fetch func(n uint16) uint8
+	n     uint16
+}
 
-	// Fetch
-	memory := c.Memory[c.PC]
-	c.PC++
+func (d *decoder) byte() uint8 {
+	b := d.fetch(d.n)
+	d.n++
+	return b
+}
+
+func (d *decoder) word() uint16 {
+	lo := d.byte()
+	hi := d.byte()
+	return uint16(hi)<<8 | uint16(lo)
+}
 
-	// Decode
-	opcode := (memory & 0xFC) >> 2 // 6 bits -> opcode
-	d := (memory & 0x2) >> 1       // 1 bit -> destination or source
-	w := (memory & 0x1)            // 1 bit -> word or byte
+// modRM reads the mod reg r/m byte and the displacement that follows it.
+func (d *decoder) modRM(inst *Instruction) {
+	b := d.byte()
+	inst.Mod = (b & 0xC0) >> 6 // 2 bits -> mode
+	inst.Reg = (b & 0x38) >> 3 // 3 bits -> register
+	inst.RM = (b & 0x07)       // 3 bits -> register or memory
+
+	switch dispLen(inst.Mod, inst.RM) {
+	case 1:
+		inst.Disp = int16(int8(d.byte()))
+	case 2:
+		inst.Disp = int16(d.word())
+	}
+}
+
+// regRM reads a mod reg r/m operand pair, the D bit of the opcode tells
+// whether REG is the destination or the source.
+func (d *decoder) regRM(inst *Instruction) {
+	d.modRM(inst)
+	reg := Operand{Kind: OperandReg, Reg: inst.Reg}
+	inst.D = (inst.Opcode & 0b10) >> 1
+	inst.Dst, inst.Src = inst.rmOperand(), reg
+	if inst.D == 1 {
+		inst.Dst, inst.Src = reg, inst.Dst
+	}
+}
 
-	// Fetch mod reg r/m
-	memory = c.Memory[c.PC]
-	c.PC++
+// group reads the mod reg r/m byte of a group opcode, which is named after
+// the operation in its REG field.
+func (d *decoder) group(inst *Instruction) error {
+	d.modRM(inst)
+	inst.Mnemonic = groupMnemonics[inst.Opcode][inst.Reg]
+	if inst.Mnemonic == "" {
+		return fmt.Errorf("invalid opcode: %02X /%d", inst.Opcode, inst.Reg)
+	}
+	return nil
+}
 
-	// Decode
-	mod := (memory & 0xC0) >> 6 // 2 bits -> mode
-	reg := (memory & 0x38) >> 3 // 3 bits -> register
-	rm := (memory & 0x07)       // 3 bits -> register or memory
+// immediate reads immediate data of the instruction width.
+func (d *decoder) immediate(inst *Instruction) {
+	inst.Src = Operand{Kind: OperandImm}
+	if inst.Width == 1 {
+		inst.Imm = uint16(d.byte())
+		return
+	}
+	inst.Imm = d.word()
+}
 
-	// Print Instruction
-	fmt.Printf("menemonic: %s\n", mnemonics[opcode])
+// clocks picks the clock count of a reg/rm instruction: rr when both
+// operands are registers, rm when memory is the source and mr when memory is
+// the destination.
+func (inst *Instruction) clocks(rr, rm, mr uint8) uint8 {
+	switch {
+	case inst.Mod == 0b11:
+		return rr
+	case inst.Dst.Kind == OperandMem:
+		return mr
+	}
+	return rm
+}
 
-	// print binary
-	fmt.Printf("opcode: %06b d: %01b w: %01b mod: %02b reg: %03b rm: %03b\n", opcode, d, w, mod, reg, rm)
+// rmOperand returns the operand selected by the r/m field.
+func (inst *Instruction) rmOperand() Operand {
+	if inst.Mod == 0b11 {
+		return Operand{Kind: OperandReg, Reg: inst.RM}
+	}
+	return Operand{Kind: OperandMem}
+}
+
+// Repeat prefixes for the string instructions.
+const (
+	prefixREP   = 0xF3 // REP, which is REPE/REPZ for CMPS and SCAS
+	prefixREPE  = prefixREP
+	prefixREPNE = 0xF2 // REPNE/REPNZ
+)
+
+// isSegmentPrefix reports whether b is one of the ES:, CS:, SS: or DS:
+// segment override prefixes, 001sr110. The SR field names the segment.
+func isSegmentPrefix(b uint8) bool {
+	return b&0b11100111 == 0b00100110
+}
+
+func decode(fetch func(n uint16) uint8) (Instruction, error) {
+	d := decoder{fetch: fetch}
+
+	var rep, seg uint8
+	opcode := d.byte()
+	for {
+		if opcode == prefixREP || opcode == prefixREPNE {
+			rep = opcode
+		} else if isSegmentPrefix(opcode) {
+			seg = opcode
+		} else {
+			break
+		}
+		opcode = d.byte()
+	}
+	prefixes := uint8(d.n - 1)
+
+	mnemonic, ok := mnemonics[opcode]
+	_, group := groupMnemonics[opcode]
+	if !ok && !group {
+		return Instruction{}, fmt.Errorf("invalid opcode: %02X", opcode)
+	}
+
+	inst := Instruction{
+		Mnemonic:    mnemonic,
+		Width:       1 + opcode&1,
+		Opcode:      opcode,
+		REP:         rep,
+		SegOverride: seg,
+	}
+
+	switch {
+	case opcode < 0x40 && opcode&0b111 <= 0b011: // ALU r/m, reg
+		d.regRM(&inst)
+		inst.Cycles = inst.clocks(3, 9, 16)
+		if mnemonic == "CMP" { // nothing is written back
+			inst.Cycles = inst.clocks(3, 9, 9)
+		}
+	case opcode < 0x40 && opcode&0b111 <= 0b101: // ALU accumulator, imm
+		inst.Dst = accumulator
+		d.immediate(&inst)
+		inst.Cycles = 4
+	case opcode >= 0x40 && opcode <= 0x4F: // INC/DEC reg16
+		inst.Width = 2
+		inst.Dst = Operand{Kind: OperandReg, Reg: opcode & 0b111}
+		inst.Cycles = 2
+	case opcode < 0x20 && opcode&0b110 == 0b110: // PUSH/POP sreg
+		// POP CS (0x0F) only works on the 8086 and 8088, later chips
+		// reuse the opcode
+		inst.Width = 2
+		sreg := Operand{Kind: OperandSeg, Reg: (opcode >> 3) & 0b11}
+		inst.Src, inst.Cycles = sreg, 10
+		if opcode&1 == 1 {
+			inst.Dst, inst.Src, inst.Cycles = sreg, Operand{}, 8
+		}
+	case opcode >= 0x50 && opcode <= 0x57: // PUSH reg16
+		inst.Width = 2
+		inst.Src = Operand{Kind: OperandReg, Reg: opcode & 0b111}
+		inst.Cycles = 11
+	case opcode >= 0x58 && opcode <= 0x5F: // POP reg16
+		inst.Width = 2
+		inst.Dst = Operand{Kind: OperandReg, Reg: opcode & 0b111}
+		inst.Cycles = 8
+	case opcode == 0x68: // PUSH imm16, an 80186 addition
+		inst.Width = 2
+		d.immediate(&inst)
+		inst.Cycles = 10
+	case opcode == 0x8F: // POP r/m16
+		err := d.group(&inst)
+		if err != nil {
+			return Instruction{}, err
+		}
+		inst.Dst = inst.rmOperand()
+		inst.Cycles = inst.clocks(8, 0, 17)
+	case opcode == 0x9C: // PUSHF
+		inst.Cycles = 10
+	case opcode == 0x9D: // POPF
+		inst.Cycles = 8
+	case opcode == 0x98: // CBW
+		inst.Cycles = 2
+	case opcode == 0x99: // CWD
+		inst.Cycles = 5
+	case opcode == 0x9E || opcode == 0x9F: // SAHF and LAHF
+		inst.Cycles = 4
+	case opcode >= 0x70 && opcode <= 0x7F: // Jcc rel8
+		inst.Dst = Operand{Kind: OperandRel}
+		inst.Disp = int16(int8(d.byte()))
+		inst.Cycles = 16 // execute charges 4 when the jump is not taken
+	case opcode >= 0x80 && opcode <= 0x83: // group 1, r/m, imm
+		err := d.group(&inst)
+		if err != nil {
+			return Instruction{}, err
+		}
+		inst.Dst = inst.rmOperand()
+		if opcode == 0x83 { // S bit, sign extended 8-bit immediate
+			inst.Src = Operand{Kind: OperandImm}
+			inst.Imm = uint16(int8(d.byte()))
+		} else {
+			d.immediate(&inst)
+		}
+		inst.Cycles = inst.clocks(4, 0, 17)
+		if inst.Mnemonic == "CMP" { // nothing is written back
+			inst.Cycles = inst.clocks(4, 0, 10)
+		}
+	case opcode == 0x84 || opcode == 0x85: // TEST r/m, reg
+		d.regRM(&inst)
+		inst.Cycles = inst.clocks(3, 9, 9)
+	case opcode >= 0x88 && opcode <= 0x8B: // MOV r/m, reg
+		d.regRM(&inst)
+		inst.Cycles = inst.clocks(2, 8, 9)
+	case opcode == 0x8D: // LEA reg16, mem
+		d.modRM(&inst)
+		inst.Dst = Operand{Kind: OperandReg, Reg: inst.Reg}
+		inst.Src = inst.rmOperand()
+		inst.Cycles = 2
+	case opcode == 0xC4 || opcode == 0xC5: // LES and LDS reg16, mem32
+		d.modRM(&inst)
+		inst.Width = 2
+		inst.Dst = Operand{Kind: OperandReg, Reg: inst.Reg}
+		inst.Src = inst.rmOperand()
+		inst.Cycles = 16
+	case opcode == 0x8C || opcode == 0x8E: // MOV r/m16, sreg and MOV sreg, r/m16
+		d.modRM(&inst)
+		inst.Width = 2
+		sreg := Operand{Kind: OperandSeg, Reg: inst.Reg & 0b11}
+		inst.Dst, inst.Src = inst.rmOperand(), sreg
+		inst.Cycles = 9
+		if opcode == 0x8E {
+			inst.Dst, inst.Src = sreg, inst.Dst
+			inst.Cycles = 8
+		}
+		if inst.Mod == 0b11 {
+			inst.Cycles = 2
+		}
+	case opcode == 0xA8 || opcode == 0xA9: // TEST accumulator, imm
+		inst.Dst = accumulator
+		d.immediate(&inst)
+		inst.Cycles = 4
+	case opcode >= 0xA0 && opcode <= 0xA3: // MOV accumulator, [addr] and back
+		// the direct address behaves exactly like mod 00 r/m 110
+		inst.RM = 0b110
+		inst.Disp = int16(d.word())
+		inst.Dst, inst.Src = accumulator, Operand{Kind: OperandMem}
+		if opcode&0b10 != 0 {
+			inst.Dst, inst.Src = inst.Src, accumulator
+		}
+		inst.Cycles = 10
+	case opcode >= 0xA4 && opcode <= 0xA7, opcode >= 0xAA && opcode <= 0xAF: // string
+		inst.Cycles = stringCycles[opcode&^1]
+		if rep != 0 {
+			inst.Cycles = 9 // plus repStringCycles for every repetition
+		}
+	case opcode >= 0xB0 && opcode <= 0xBF: // MOV reg, imm
+		inst.Width = 1 + (opcode&0b1000)>>3 // W is bit 3 here
+		inst.Dst = Operand{Kind: OperandReg, Reg: opcode & 0b111}
+		d.immediate(&inst)
+		inst.Cycles = 4
+	case opcode >= 0xE0 && opcode <= 0xE2: // LOOPcc rel8
+		inst.Dst = Operand{Kind: OperandRel}
+		inst.Disp = int16(int8(d.byte()))
+		inst.Cycles = [3]uint8{19, 18, 17}[opcode-0xE0] // when taken, see execute
+	case opcode == 0xE3: // JCXZ rel8
+		inst.Dst = Operand{Kind: OperandRel}
+		inst.Disp = int16(int8(d.byte()))
+		inst.Cycles = 18 // execute charges 6 when the jump is not taken
+	case opcode == 0xE8 || opcode == 0xE9: // CALL and JMP rel16
+		inst.Dst = Operand{Kind: OperandRel}
+		inst.Disp = int16(d.word())
+		inst.Cycles = 15
+		if opcode == 0xE8 {
+			inst.Cycles = 19
+		}
+	case opcode == 0x9A || opcode == 0xEA: // CALL and JMP ptr16:16
+		inst.Dst = Operand{Kind: OperandFar}
+		inst.Imm = d.word()
+		inst.Seg = d.word()
+		inst.Cycles = 15
+		if opcode == 0x9A {
+			inst.Cycles = 28
+		}
+	case opcode == 0xC2 || opcode == 0xCA: // RET and RETF imm16
+		inst.Width = 2
+		d.immediate(&inst)
+		inst.Cycles = 12
+		if opcode == 0xCA {
+			inst.Cycles = 17
+		}
+	case opcode == 0xC3: // RET
+		inst.Cycles = 8
+	case opcode == 0xCB: // RETF
+		inst.Cycles = 18
+	case opcode == 0xCC: // INT 3
+		inst.Src = Operand{Kind: OperandImm}
+		inst.Imm = 3
+		inst.Cycles = 52
+	case opcode == 0xCD: // INT imm8
+		inst.Width = 1
+		d.immediate(&inst)
+		inst.Cycles = 51
+	case opcode == 0xCE: // INTO
+		inst.Cycles = 53 // execute charges 4 when OF is clear
+	case opcode == 0xCF: // IRET
+		inst.Cycles = 24
+	case opcode == 0xEB: // JMP rel8
+		inst.Dst = Operand{Kind: OperandRel}
+		inst.Disp = int16(int8(d.byte()))
+		inst.Cycles = 15
+	case opcode == 0x90: // NOP, really XCHG AX, AX
+		inst.Cycles = 3
+	case opcode >= 0x91 && opcode <= 0x97: // XCHG AX, reg16
+		inst.Width = 2
+		inst.Dst = accumulator
+		inst.Src = Operand{Kind: OperandReg, Reg: opcode & 0b111}
+		inst.Cycles = 3
+	case opcode == 0x86 || opcode == 0x87: // XCHG r/m, reg
+		d.regRM(&inst)
+		inst.Cycles = inst.clocks(4, 17, 17)
+	case opcode >= 0xE4 && opcode <= 0xE7 || opcode >= 0xEC && opcode <= 0xEF: // IN and OUT
+		port := Operand{Kind: OperandReg, Reg: 2} // DX
+		inst.Cycles = 8
+		if opcode < 0xEC {
+			port = Operand{Kind: OperandImm}
+			inst.Imm = uint16(d.byte())
+			inst.Cycles = 10
+		}
+		inst.Dst, inst.Src = accumulator, port
+		if opcode&0b10 != 0 { // OUT
+			inst.Dst, inst.Src = port, accumulator
+		}
+	case opcode < 0x40 && opcode&0b111 == 0b111: // DAA, DAS, AAA and AAS
+		inst.Cycles = 4
+		if opcode >= 0x37 {
+			inst.Cycles = 8
+		}
+	case opcode == 0xD4 || opcode == 0xD5: // AAM and AAD, the imm8 is the base
+		inst.Width = 1
+		d.immediate(&inst)
+		inst.Cycles = 83
+		if opcode == 0xD5 {
+			inst.Cycles = 60
+		}
+	case opcode == 0xD7: // XLAT
+		inst.Cycles = 11
+	case opcode == 0xF4: // HLT
+		inst.Cycles = 2
+	case opcode == 0xF5 || opcode >= 0xF8 && opcode <= 0xFD: // flag instructions
+		inst.Cycles = 2
+	case opcode == 0xC6 || opcode == 0xC7: // MOV r/m, imm
+		err := d.group(&inst)
+		if err != nil {
+			return Instruction{}, err
+		}
+		inst.Dst = inst.rmOperand()
+		d.immediate(&inst)
+		inst.Cycles = inst.clocks(4, 0, 10)
+	case opcode >= 0xD0 && opcode <= 0xD3: // group 2, shifts and rotates
+		err := d.group(&inst)
+		if err != nil {
+			return Instruction{}, err
+		}
+		inst.Dst = inst.rmOperand()
+		if opcode >= 0xD2 { // the count is in CL
+			inst.Src = Operand{Kind: OperandReg, Reg: 1}
+			inst.Cycles = inst.clocks(8, 0, 20) // execute adds 4 per bit
+			break
+		}
+		inst.Src = Operand{Kind: OperandImm}
+		inst.Imm = 1
+		inst.Cycles = inst.clocks(2, 0, 15)
+	case opcode == 0xF6 || opcode == 0xF7: // group 3
+		err := d.group(&inst)
+		if err != nil {
+			return Instruction{}, err
+		}
+		if inst.Mnemonic == "TEST" {
+			inst.Dst = inst.rmOperand()
+			d.immediate(&inst)
+			inst.Cycles = inst.clocks(5, 0, 11)
+			break
+		}
+		if inst.Mnemonic == "NOT" || inst.Mnemonic == "NEG" {
+			inst.Dst = inst.rmOperand()
+			inst.Cycles = inst.clocks(3, 0, 16)
+			break
+		}
+		inst.Src = inst.rmOperand()
+		switch inst.Mnemonic {
+		case "MUL":
+			inst.Cycles = 70
+			if inst.Width == 2 {
+				inst.Cycles = 118
+			}
+		case "IMUL":
+			inst.Cycles = 80
+			if inst.Width == 2 {
+				inst.Cycles = 128
+			}
+		case "DIV":
+			inst.Cycles = 80
+			if inst.Width == 2 {
+				inst.Cycles = 144
+			}
+		case "IDIV":
+			inst.Cycles = 101
+			if inst.Width == 2 {
+				inst.Cycles = 165
+			}
+		}
+		if inst.Mod != 0b11 {
+			inst.Cycles += 6
+		}
+	case opcode == 0xFE || opcode == 0xFF: // group 4 and 5
+		err := d.group(&inst)
+		if err != nil {
+			return Instruction{}, err
+		}
+		inst.Dst = inst.rmOperand()
+		inst.Cycles = inst.clocks(3, 0, 15)
+		switch inst.Reg {
+		case 2: // CALL near, r/m16
+			inst.Cycles = inst.clocks(16, 0, 21)
+		case 3: // CALL far, m16:16
+			inst.Cycles = 37
+		case 4: // JMP near, r/m16
+			inst.Cycles = inst.clocks(11, 0, 18)
+		case 5: // JMP far, m16:16
+			inst.Cycles = 24
+		case 6: // PUSH r/m16
+			inst.Dst, inst.Src = Operand{}, inst.Dst
+			inst.Cycles = inst.clocks(11, 16, 0)
+		}
+	}
+
+	length, err := calcLen(opcode, inst.Mod, inst.Reg, inst.RM)
+	if err != nil {
+		return Instruction{}, err
+	}
+	inst.Size = prefixes + length
+	inst.W = inst.Width - 1
 
-	return Instruction{}, nil
+	return inst, nil
 }
 
+// DecodeInstruction decodes the instruction at CS:IP without executing it.
+// With Verbose set it also prints the fields of the instruction.
+func (c *CPU) DecodeInstruction() (Instruction, error) {
+	inst, err := decode(func(n uint16) uint8 {
+		return c.Memory[physicalAddress(c.CS, c.IP+n)] // fetches are not watched
+	})
+	if err != nil {
+		return inst, err
+	}
+
+	if c.Verbose {
+		// Print Instruction
+		fmt.Printf("mnemonic: %s\n", inst.Mnemonic)
+
+		// print binary
+		fmt.Printf("opcode: %08b d: %01b w: %01b mod: %02b reg: %03b rm: %03b\n",
+			inst.Opcode, inst.D, inst.W, inst.Mod, inst.Reg, inst.RM)
+	}
+
+	return inst, nil
+}
+
+// comOrigin is where a COM program starts within its segment, after the
+// 256 byte program segment prefix.
+const comOrigin = 0x0100
+
+// LoadProgram loads the file filename like LoadProgramFromBytes does.
 func (c *CPU) LoadProgram(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -288,30 +930,180 @@ func (c *CPU) LoadProgram(filename string) error {
 	}
 	defer file.Close()
 
-	b, err := io.ReadAll(file)
+	return c.LoadProgramFromReader(file)
+}
+
+// LoadProgramFromReader loads everything read from r like
+// LoadProgramFromBytes does.
+func (c *CPU) LoadProgramFromReader(r io.Reader) error {
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
 
-	i := 0
-	for ; i < len(b); i++ {
-		c.Memory[i] = b[i]
+	return c.LoadProgramFromBytes(b)
+}
+
+// LoadProgramFromBytes copies data to CS:0100, where COM programs start, and
+// points IP at it.
+func (c *CPU) LoadProgramFromBytes(data []byte) error {
+	start := int(physicalAddress(c.CS, comOrigin))
+	if start+len(data) > len(c.Memory) {
+		return fmt.Errorf("program of %d bytes does not fit in memory at %04X:%04X", len(data), c.CS, comOrigin)
 	}
 
-	c.programSize = i
+	copy(c.Memory[start:], data)
+
+	c.IP = comOrigin
+	c.programStart = start
+	c.programSize = len(data)
 	return nil
 }
 
-func (c *CPU) Run() error {
-	for {
-		// Fetch
-		//opcode := c.Memory[c.PC]
+// Run executes instructions until a HLT, an error or a limit stops it. Control
+// may leave the loaded program, for a far CALL or an interrupt handler, but
+// running into the byte just past its end returns ErrEndOfProgram.
+//
+// A limit greater than zero caps the number of instructions executed, so a
+// runaway program returns MaxInstructionsExceeded instead of looping forever.
+// MaxInstructions does the same for the count kept across runs. Running a
+// CPU that is already halted returns ErrHalted.
+func (c *CPU) Run(limit int) error {
+	return c.RunContext(context.Background(), limit)
+}
 
+// RunContext is Run with a context, it returns ctx.Err() as soon as ctx is
+// done.
+func (c *CPU) RunContext(ctx context.Context, limit int) error {
+	if c.Halted {
+		return ErrHalted
 	}
 
-	return nil
+	end := uint32(c.programStart + c.programSize)
+	for n := 0; ; n++ {
+		if c.programSize > 0 && physicalAddress(c.CS, c.IP) == end {
+			return ErrEndOfProgram
+		}
+
+		if limit > 0 && n == limit {
+			return &MaxInstructionsExceeded{Count: uint64(limit)}
+		}
+
+		if c.MaxInstructions > 0 && c.Instructions >= c.MaxInstructions {
+			return &MaxInstructionsExceeded{Count: c.Instructions}
+		}
+
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		if _, ok := c.breakpoints[c.IP]; ok && !c.resumingAt(c.IP) {
+			c.lastBreakpoint = &BreakpointHit{Addr: c.IP}
+			return c.lastBreakpoint
+		}
+
+		_, err = c.Step()
+		if err != nil {
+			return err
+		}
+
+		if c.Halted {
+			return nil
+		}
+	}
+}
+
+// ErrEndOfProgram is returned by Run when execution falls off the end of the
+// loaded program without a HLT or a DOS exit.
+var ErrEndOfProgram = errors.New("ran past the end of the program")
+
+// MaxInstructionsExceeded is returned by Run when it has run the limit it
+// was given, or when the CPU has run MaxInstructions instructions. Count is
+// the limit that was hit.
+type MaxInstructionsExceeded struct {
+	Count uint64
+}
+
+func (e *MaxInstructionsExceeded) Error() string {
+	return fmt.Sprintf("instruction limit of %d exceeded", e.Count)
+}
+
+// ErrHalted is returned by Step once a HLT has run, until Halted is cleared.
+var ErrHalted = errors.New("cpu is halted")
+
+// Step runs the single instruction at CS:IP and returns it. IP is advanced
+// past the instruction before it runs, as on the real chip.
+func (c *CPU) Step() (Instruction, error) {
+	if c.Halted {
+		return Instruction{}, ErrHalted
+	}
+
+	inst, err := c.DecodeInstruction()
+	if err != nil {
+		return inst, err
+	}
+	c.lastBreakpoint = nil
+
+	for _, fn := range c.hooks[inst.Opcode] {
+		fn(c, inst)
+	}
+
+	var trace traceState
+	if c.trace != nil {
+		trace = c.traceBefore(inst)
+	}
+
+	c.Cycles += uint64(inst.Cycles) + uint64(c.memoryClocks(inst))
+	c.Instructions++
+	c.IP += uint16(inst.Size)
+
+	c.watchHit = nil // accesses from outside an instruction don't count
+	err = c.execute(inst)
+	if err == nil && c.watchHit != nil {
+		err = c.watchHit
+	}
+
+	if c.trace != nil {
+		c.traceAfter(inst, trace)
+	}
+
+	return inst, err
+}
+
+// Reset puts the CPU back in the state ResetRegisters leaves it in and clears
+// all of memory, the loaded program included. Configuration such as the
+// interrupt handlers, the ports and the output writer is kept.
+func (c *CPU) Reset() {
+	c.ResetRegisters()
+	c.Memory = [len(c.Memory)]byte{}
+	c.programStart, c.programSize = 0, 0
+}
+
+// ResetRegisters clears every register and flag, Halted, ExitCode and the
+// counters, leaving memory alone so the loaded program can run again. IP and
+// SP are set the way DOS starts a COM program, 0100 and FFFE, with all
+// segments at zero. The reserved flag bits keep the values they always have
+// on the 8086.
+func (c *CPU) ResetRegisters() {
+	c.AX, c.BX, c.CX, c.DX = 0, 0, 0, 0
+	c.SI, c.DI, c.BP = 0, 0, 0
+	c.CS, c.DS, c.ES, c.SS = 0, 0, 0, 0
+	c.PC, c.FL, c.Flag = 0, flagsFixed, 0
+	c.IP = comOrigin
+	c.SP = 0xFFFE
+	c.Halted, c.ExitCode = false, 0
+	c.Cycles, c.Instructions = 0, 0
+	c.segmentOverride = nil
+	c.lastBreakpoint = nil
 }
 
 func NewCPU() *CPU {
-	return &CPU{}
+	return &CPU{
+		FL:      flagsFixed,
+		output:  os.Stdout,
+		input:   os.Stdin,
+		PortIn:  unconnectedPortIn,
+		PortOut: unconnectedPortOut,
+	}
 }
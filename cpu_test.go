@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// newTestCPU returns a CPU in its reset state with code loaded at 0000:0100.
+func newTestCPU(t *testing.T, code ...byte) *CPU {
+	t.Helper()
+	c := NewCPU()
+	c.ResetRegisters()
+	err := c.LoadProgramFromBytes(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// hlt is the HLT opcode, which ends most test programs.
+const hlt = 0xF4
+
+// programTest runs code, which must end in HLT, and checks the registers
+// named in regs and the flags in set and clear. check, if not nil, looks at
+// anything else.
+type programTest struct {
+	name  string
+	setup func(c *CPU)
+	code  []byte
+	regs  map[string]uint16 // by the names the trace uses, e.g. "AX" or "FL"
+	set   uint16            // flags that must be set
+	clear uint16            // flags that must be clear
+	check func(t *testing.T, c *CPU)
+}
+
+func runProgramTests(t *testing.T, tests []programTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCPU(t, tt.code...)
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+
+			err := c.Run(1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.Halted {
+				t.Fatal("program did not reach its HLT")
+			}
+
+			for _, r := range traceRegisters {
+				want, ok := tt.regs[r.name]
+				if got := *r.reg(c); ok && got != want {
+					t.Errorf("%s = %04X, want %04X", r.name, got, want)
+				}
+			}
+			if c.FL&tt.set != tt.set {
+				t.Errorf("flags %s, want %s set", c.flagsString(), flagList(tt.set))
+			}
+			if c.FL&tt.clear != 0 {
+				t.Errorf("flags %s, want %s clear", c.flagsString(), flagList(tt.clear))
+			}
+			if tt.check != nil {
+				tt.check(t, c)
+			}
+		})
+	}
+}
+
+// flagList names the flags in f.
+func flagList(f uint16) string {
+	var s string
+	for _, n := range flagNames {
+		if f&n.flag != 0 {
+			s += n.name + " "
+		}
+	}
+	return s
+}
+
+func TestRunLeavesProgram(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(c *CPU)
+		code   []byte
+		wantAX uint16
+	}{
+		{
+			name: "far CALL",
+			setup: func(c *CPU) {
+				// 2000:0000 mov ax, 1234h; retf
+				copy(c.Memory[0x20000:], []byte{0xB8, 0x34, 0x12, 0xCB})
+			},
+			code:   []byte{0x9A, 0x00, 0x00, 0x00, 0x20, 0xF4}, // call 2000:0000; hlt
+			wantAX: 0x1234,
+		},
+		{
+			name: "INT with a guest handler",
+			setup: func(c *CPU) {
+				// 3000:0000 mov ax, 5678h; iret
+				copy(c.Memory[0x30000:], []byte{0xB8, 0x78, 0x56, 0xCF})
+				c.WriteMemWord(0, 0x60*4, 0x0000)
+				c.WriteMemWord(0, 0x60*4+2, 0x3000)
+			},
+			code:   []byte{0xCD, 0x60, 0xF4}, // int 60h; hlt
+			wantAX: 0x5678,
+		},
+		{
+			name: "far JMP and back",
+			setup: func(c *CPU) {
+				// 2000:0000 mov ax, 9ABCh; jmp 0000:0105
+				copy(c.Memory[0x20000:], []byte{0xB8, 0xBC, 0x9A, 0xEA, 0x05, 0x01, 0x00, 0x00})
+			},
+			code:   []byte{0xEA, 0x00, 0x00, 0x00, 0x20, 0xF4}, // jmp 2000:0000; hlt
+			wantAX: 0x9ABC,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCPU(t, tt.code...)
+			tt.setup(c)
+
+			err := c.Run(100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.Halted {
+				t.Error("Run returned before the HLT")
+			}
+			if c.AX != tt.wantAX {
+				t.Errorf("AX = %04X, want %04X", c.AX, tt.wantAX)
+			}
+		})
+	}
+}
+
+func TestRunEndOfProgram(t *testing.T) {
+	c := newTestCPU(t, 0xB8, 0x01, 0x00) // mov ax, 1
+
+	err := c.Run(0)
+	if !errors.Is(err, ErrEndOfProgram) {
+		t.Fatalf("Run = %v, want ErrEndOfProgram", err)
+	}
+	if c.AX != 1 || c.IP != 0x103 {
+		t.Errorf("AX = %04X IP = %04X, want 0001 0103", c.AX, c.IP)
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	loop := []byte{0x90, 0xEB, 0xFD} // nop; jmp nop
+
+	tests := []struct {
+		name  string
+		limit int
+		max   uint64
+		want  uint64
+	}{
+		{"Run limit", 50, 0, 50},
+		{"MaxInstructions", 0, 100, 100},
+		{"Run limit first", 10, 100, 10},
+		{"MaxInstructions first", 100, 10, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCPU(t, loop...)
+			c.MaxInstructions = tt.max
+
+			err := c.Run(tt.limit)
+			var exceeded *MaxInstructionsExceeded
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("Run = %v, want MaxInstructionsExceeded", err)
+			}
+			if exceeded.Count != tt.want || c.Instructions != tt.want {
+				t.Errorf("Count = %d Instructions = %d, want %d", exceeded.Count, c.Instructions, tt.want)
+			}
+		})
+	}
+}
+
+// wantMem checks the little-endian word, or byte when width is 1, at the
+// physical address addr.
+func wantMem(t *testing.T, c *CPU, addr uint32, width uint8, want uint16) {
+	t.Helper()
+	got := uint16(c.Memory[addr])
+	if width == 2 {
+		got |= uint16(c.Memory[addr+1]) << 8
+	}
+	if got != want {
+		t.Errorf("memory at %05X = %04X, want %04X", addr, got, want)
+	}
+}
+
+func TestDecodeInstruction(t *testing.T) {
+	c := newTestCPU(t, 0x26, 0x89, 0x47, 0xFE) // mov es:[bx-2], ax
+
+	inst, err := c.DecodeInstruction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Instruction{
+		Mnemonic: "MOV",
+		Dst:      Operand{Kind: OperandMem},
+		Src:      Operand{Kind: OperandReg, Reg: 0},
+		Width:    2,
+		Opcode:   0x89,
+		W:        1,
+		Mod:      0b01,
+		Reg:      0b000,
+		RM:       0b111,
+		Disp:     -2,
+		Size:     4,
+
+		SegOverride: 0x26,
+	}
+	inst.Cycles = 0 // covered by TestCycles
+	if inst != want {
+		t.Errorf("DecodeInstruction = %+v, want %+v", inst, want)
+	}
+	if c.IP != 0x0100 {
+		t.Errorf("IP = %04X, DecodeInstruction must not move it", c.IP)
+	}
+}
+
+func TestCalcLen(t *testing.T) {
+	tests := []struct {
+		name         string
+		opcode       uint8
+		mod, reg, rm uint8
+		want         uint8
+		wantErr      bool
+	}{
+		{"ADD r/m, reg register", 0x00, 0b11, 0, 0, 2, false},
+		{"ADD r/m, reg disp8", 0x01, 0b01, 0, 0, 3, false},
+		{"ADD r/m, reg disp16", 0x02, 0b10, 0, 0, 4, false},
+		{"ADD r/m, reg direct", 0x03, 0b00, 0, 0b110, 4, false},
+		{"ADD AL, imm8", 0x04, 0, 0, 0, 2, false},
+		{"ADD AX, imm16", 0x05, 0, 0, 0, 3, false},
+		{"INC AX", 0x40, 0, 0, 0, 1, false},
+		{"JE rel8", 0x74, 0, 0, 0, 2, false},
+		{"ADD r/m8, imm8", 0x80, 0b11, 0, 0, 3, false},
+		{"ADD r/m16, imm16 disp16", 0x81, 0b10, 0, 0, 6, false},
+		{"ADD r/m16, imm8", 0x83, 0b00, 0, 0b110, 5, false},
+		{"MOV AL, [addr]", 0xA0, 0, 0, 0, 3, false},
+		{"MOV AL, imm8", 0xB0, 0, 0, 0, 2, false},
+		{"MOV AX, imm16", 0xB8, 0, 0, 0, 3, false},
+		{"RET imm16", 0xC2, 0, 0, 0, 3, false},
+		{"MOV r/m16, imm16 disp8", 0xC7, 0b01, 0, 0, 5, false},
+		{"CALL far", 0x9A, 0, 0, 0, 5, false},
+		{"TEST r/m8, imm8", 0xF6, 0b11, 0, 0, 3, false},
+		{"NOT r/m16", 0xF7, 0b11, 2, 0, 2, false},
+		{"TEST r/m16, imm16 direct", 0xF7, 0b00, 0, 0b110, 6, false},
+		{"SHL r/m, CL", 0xD3, 0b11, 4, 0, 2, false},
+		{"HLT", 0xF4, 0, 0, 0, 1, false},
+		{"invalid", 0xF1, 0, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := calcLen(tt.opcode, tt.mod, tt.reg, tt.rm)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: calcLen error = %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: calcLen = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	c := newTestCPU(t,
+		0xB9, 0x02, 0x00, // mov cx, 2
+		0x40,       // inc ax
+		0xE2, 0xFD, // loop -3
+		hlt,
+	)
+
+	steps := []struct {
+		mnemonic string
+		ip       uint16
+		ax, cx   uint16
+	}{
+		{"MOV", 0x0103, 0, 2},
+		{"INC", 0x0104, 1, 2},
+		{"LOOP", 0x0103, 1, 1},
+		{"INC", 0x0104, 2, 1},
+		{"LOOP", 0x0106, 2, 0},
+		{"HLT", 0x0107, 2, 0},
+	}
+
+	for i, s := range steps {
+		inst, err := c.Step()
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if inst.Mnemonic != s.mnemonic || c.IP != s.ip || c.AX != s.ax || c.CX != s.cx {
+			t.Errorf("step %d: %s IP=%04X AX=%04X CX=%04X, want %s IP=%04X AX=%04X CX=%04X",
+				i, inst.Mnemonic, c.IP, c.AX, c.CX, s.mnemonic, s.ip, s.ax, s.cx)
+		}
+	}
+	if c.Instructions != uint64(len(steps)) {
+		t.Errorf("Instructions = %d, want %d", c.Instructions, len(steps))
+	}
+
+	_, err := c.Step()
+	if !errors.Is(err, ErrHalted) {
+		t.Errorf("Step after HLT = %v, want ErrHalted", err)
+	}
+	err = c.Run(0)
+	if !errors.Is(err, ErrHalted) {
+		t.Errorf("Run after HLT = %v, want ErrHalted", err)
+	}
+}
+
+func TestStepInvalidOpcode(t *testing.T) {
+	c := newTestCPU(t, 0xF1)
+
+	_, err := c.Step()
+	if err == nil {
+		t.Fatal("Step of F1 did not fail")
+	}
+	if c.IP != 0x0100 || c.Instructions != 0 {
+		t.Errorf("IP = %04X Instructions = %d, want 0100 0", c.IP, c.Instructions)
+	}
+}
+
+func TestReset(t *testing.T) {
+	c := newTestCPU(t, 0xB8, 0x34, 0x12, hlt)
+	c.DS, c.ES, c.SS, c.BX, c.SP = 1, 2, 3, 4, 5
+	err := c.Run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ResetRegisters()
+	want := CPURegisters{IP: 0x0100, SP: 0xFFFE, FL: flagsFixed}
+	if got := c.DumpRegistersOnly(); got != want {
+		t.Errorf("after ResetRegisters %+v, want %+v", got, want)
+	}
+	if c.Memory[0x0100] != 0xB8 {
+		t.Error("ResetRegisters cleared memory")
+	}
+	err = c.Run(0)
+	if err != nil || c.AX != 0x1234 {
+		t.Errorf("Run after ResetRegisters = %v AX = %04X, want nil 1234", err, c.AX)
+	}
+
+	c.Reset()
+	if got := c.DumpRegistersOnly(); got != want {
+		t.Errorf("after Reset %+v, want %+v", got, want)
+	}
+	if c.Memory != [len(c.Memory)]byte{} {
+		t.Error("Reset left memory")
+	}
+	if c.programSize != 0 {
+		t.Errorf("programSize = %d after Reset, want 0", c.programSize)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	c := newTestCPU(t, 0xEB, 0xFE) // jmp $
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := c.RunContext(ctx, 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunContext = %v, want context.Canceled", err)
+	}
+	if c.Instructions != 0 {
+		t.Errorf("Instructions = %d, want 0", c.Instructions)
+	}
+}
+
+func TestLoadProgram(t *testing.T) {
+	code := []byte{0xB8, 0x34, 0x12, hlt}
+
+	c := NewCPU()
+	c.CS = 0x1000
+	err := c.LoadProgramFromReader(bytes.NewReader(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Memory[0x10100:0x10104], code) || c.IP != 0x0100 {
+		t.Fatalf("program at %X IP = %04X, want it at 10100 IP = 0100", c.Memory[0x10100:0x10104], c.IP)
+	}
+	err = c.Run(0)
+	if err != nil || c.AX != 0x1234 {
+		t.Errorf("Run = %v AX = %04X, want nil 1234", err, c.AX)
+	}
+
+	err = c.LoadProgramFromBytes(make([]byte, len(c.Memory)))
+	if err == nil {
+		t.Error("LoadProgramFromBytes past the end of memory did not fail")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+// instructionCycles holds the clocks of every opcode in its register form,
+// as decode counts them, zero for prefixes and invalid opcodes. Memory
+// operands cost more, see memoryClocks.
+var instructionCycles = func() (t [256]uint8) {
+	for op := 0; op < len(t); op++ {
+		if op == prefixREP || op == prefixREPNE || isSegmentPrefix(uint8(op)) {
+			continue
+		}
+		inst, err := decode(func(n uint16) uint8 {
+			switch n {
+			case 0:
+				return uint8(op)
+			case 1:
+				return 0b11000000 // mod=11, register operands
+			}
+			return 0
+		})
+		if err == nil {
+			t[op] = inst.Cycles
+		}
+	}
+	return t
+}()
+
+// CyclesPerInstruction returns the clocks of opcode with register operands,
+// or zero when opcode is a prefix or not a valid 8086 opcode. Where the
+// clocks depend on the group field of the ModRM byte, it is the first
+// instruction of the group.
+func CyclesPerInstruction(opcode uint8) uint8 {
+	return instructionCycles[opcode]
+}
+
+// chargeInstead makes the clocks of inst, which Step charged before it ran,
+// count as clocks instead. Conditional jumps and INTO are decoded with the
+// clocks of the jump taken and call it when it is not.
+func (c *CPU) chargeInstead(inst Instruction, clocks uint8) {
+	c.Cycles -= uint64(inst.Cycles - clocks)
+}
+
+// eaClocks is the time the 8086 takes to calculate an effective address,
+// from the table in the manual.
+func eaClocks(mod, rm uint8) uint8 {
+	if mod == 0b00 && rm == 0b110 {
+		return 6 // displacement only
+	}
+
+	var clocks uint8
+	switch rm {
+	case 0b000, 0b011: // BX+SI, BP+DI
+		clocks = 7
+	case 0b001, 0b010: // BX+DI, BP+SI
+		clocks = 8
+	default: // SI, DI, BP, BX
+		clocks = 5
+	}
+	if mod != 0b00 {
+		clocks += 4 // displacement
+	}
+	return clocks
+}
+
+// memoryClocks returns what a memory operand adds to the clocks of inst: the
+// effective address calculation, 2 for a segment override prefix and 4 for
+// each word transferred at an odd address, as the bus has to do it in two
+// cycles. A word that is both read and written counts once, LDS and LES
+// transfer two and LEA none. Must be called before inst runs, as it looks at
+// the registers.
+func (c *CPU) memoryClocks(inst Instruction) uint8 {
+	var clocks uint8
+	if inst.SegOverride != 0 {
+		clocks += 2
+	}
+
+	if inst.Mod == 0b11 || inst.Dst.Kind != OperandMem && inst.Src.Kind != OperandMem {
+		return clocks
+	}
+	if inst.Opcode < 0xA0 || inst.Opcode > 0xA3 { // MOV AX, [addr] has it built in
+		clocks += eaClocks(inst.Mod, inst.RM)
+	}
+	_, off := c.operandAddr(inst)
+	if inst.Width == 2 && off&1 == 1 {
+		switch inst.Mnemonic {
+		case "LEA": // only computes the address
+		case "LDS", "LES":
+			clocks += 2 * 4 // the offset and the segment
+		default:
+			clocks += 4
+		}
+	}
+	return clocks
+}
@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+// Expected clocks come from the instruction timings in the 8086 manual, the
+// HLT that ends every program takes 2.
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *CPU)
+		code  []byte
+		want  uint64
+	}{
+		{"MOV reg, imm", nil, []byte{0xB8, 0x01, 0x00, 0xF4}, 4 + 2},
+		{"ADD reg, [BX+SI]", nil, []byte{0x03, 0x00, 0xF4}, 9 + 7 + 2},
+		{"MOV [disp], reg at an odd address", nil, []byte{0x89, 0x06, 0x01, 0x02, 0xF4}, 9 + 6 + 4 + 2},
+		{"LEA at an odd address", func(c *CPU) { c.SI = 1 }, []byte{0x8D, 0x04, 0xF4}, 2 + 5 + 2},
+		{"LDS", func(c *CPU) { c.SI = 2 }, []byte{0xC5, 0x1C, 0xF4}, 16 + 5 + 2},
+		{"LDS at an odd address", func(c *CPU) { c.SI = 1 }, []byte{0xC5, 0x1C, 0xF4}, 16 + 5 + 2*4 + 2},
+		{"LES at an odd address", func(c *CPU) { c.SI = 1 }, []byte{0xC4, 0x1C, 0xF4}, 16 + 5 + 2*4 + 2},
+		{"segment override", nil, []byte{0x26, 0x8B, 0x07, 0xF4}, 8 + 5 + 2 + 2},
+		{"JE taken", func(c *CPU) { c.SetFlag(FlagZF, true) }, []byte{0x74, 0x00, 0xF4}, 16 + 2},
+		{"JE not taken", nil, []byte{0x74, 0x00, 0xF4}, 4 + 2},
+		{"JCXZ taken", nil, []byte{0xE3, 0x00, 0xF4}, 18 + 2},
+		{"JCXZ not taken", func(c *CPU) { c.CX = 1 }, []byte{0xE3, 0x00, 0xF4}, 6 + 2},
+		{"LOOP", func(c *CPU) { c.CX = 3 }, []byte{0xE2, 0xFE, 0xF4}, 17 + 17 + 5 + 2},
+		{"LOOPE", func(c *CPU) { c.CX = 3; c.SetFlag(FlagZF, true) }, []byte{0xE1, 0xFE, 0xF4}, 18 + 18 + 6 + 2},
+		{"LOOPNE", func(c *CPU) { c.CX = 3 }, []byte{0xE0, 0xFE, 0xF4}, 19 + 19 + 5 + 2},
+		{"INTO, OF clear", nil, []byte{0xCE, 0xF4}, 4 + 2},
+		{"SHL reg, 1", nil, []byte{0xD1, 0xE0, 0xF4}, 2 + 2},
+		{"SHL reg, CL", func(c *CPU) { c.CX = 3 }, []byte{0xD3, 0xE0, 0xF4}, 8 + 4*3 + 2},
+		{"SHL mem, CL", func(c *CPU) { c.CX = 3; c.BX = 0x200 }, []byte{0xD3, 0x27, 0xF4}, 20 + 5 + 4*3 + 2},
+		{"REP STOSB", func(c *CPU) { c.CX = 3; c.DI = 0x300 }, []byte{0xF3, 0xAA, 0xF4}, 9 + 3*10 + 2},
+		{
+			"sequence", nil,
+			[]byte{
+				0xB9, 0x02, 0x00, // mov cx, 2        4
+				0xB8, 0x00, 0x00, // mov ax, 0        4
+				0x05, 0x01, 0x00, // add ax, 1        4, twice
+				0xE2, 0xFB, //       loop add         17 then 5
+				0x74, 0x00, //       je +0            4, not taken
+				0xF4, //             hlt              2
+			},
+			4 + 4 + 2*4 + 17 + 5 + 4 + 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCPU(t, tt.code...)
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+
+			err := c.Run(100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Cycles != tt.want {
+				t.Errorf("Cycles = %d, want %d", c.Cycles, tt.want)
+			}
+		})
+	}
+}
+
+func TestCyclesPerInstruction(t *testing.T) {
+	tests := []struct {
+		opcode uint8
+		want   uint8
+	}{
+		{0x01, 3},  // ADD r/m, reg
+		{0x8B, 2},  // MOV reg, r/m
+		{0xB8, 4},  // MOV reg, imm
+		{0xF4, 2},  // HLT
+		{0xCD, 51}, // INT imm8
+		{0xF3, 0},  // REP is a prefix
+		{0x26, 0},  // so is ES:
+		{0xF1, 0},  // not an opcode
+	}
+
+	for _, tt := range tests {
+		if got := CyclesPerInstruction(tt.opcode); got != tt.want {
+			t.Errorf("CyclesPerInstruction(%02X) = %d, want %d", tt.opcode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BreakpointHit is returned by Run when it stops in front of an instruction
+// at a breakpoint. The instruction has not run yet.
+type BreakpointHit struct {
+	Addr uint16
+}
+
+func (e *BreakpointHit) Error() string {
+	return fmt.Sprintf("breakpoint at %04X", e.Addr)
+}
+
+// SetBreakpoint makes Run stop before the instruction at offset addr, in
+// whatever code segment is current. Calling Run again runs the instruction
+// it stopped at, so execution continues from the breakpoint.
+func (c *CPU) SetBreakpoint(addr uint16) {
+	if c.breakpoints == nil {
+		c.breakpoints = make(map[uint16]struct{})
+	}
+	c.breakpoints[addr] = struct{}{}
+}
+
+// resumingAt reports whether Run last stopped at the breakpoint at addr and
+// that instruction has not run yet.
+func (c *CPU) resumingAt(addr uint16) bool {
+	return c.lastBreakpoint != nil && c.lastBreakpoint.Addr == addr
+}
+
+// ClearBreakpoint removes the breakpoint at addr, if there is one.
+func (c *CPU) ClearBreakpoint(addr uint16) {
+	delete(c.breakpoints, addr)
+}
+
+// ClearAllBreakpoints removes every breakpoint.
+func (c *CPU) ClearAllBreakpoints() {
+	c.breakpoints = nil
+}
+
+// ListBreakpoints returns the breakpoint addresses in ascending order.
+func (c *CPU) ListBreakpoints() []uint16 {
+	addrs := make([]uint16, 0, len(c.breakpoints))
+	for addr := range c.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// WatchpointHit is returned by Run when an instruction reads or writes a
+// watched address. It is reported once the instruction has finished, Value
+// is the byte or word that was read or written.
+type WatchpointHit struct {
+	Seg, Addr uint16 // the watchpoint, as it was set
+	IsWrite   bool
+	Value     uint16
+}
+
+func (e *WatchpointHit) Error() string {
+	access := "read"
+	if e.IsWrite {
+		access = "write"
+	}
+	return fmt.Sprintf("watchpoint at %04X:%04X: %s of %04X", e.Seg, e.Addr, access, e.Value)
+}
+
+// watchpoint is a watched byte, at seg:off.
+type watchpoint struct {
+	seg, off uint16
+}
+
+// SetWriteWatchpoint makes Run stop after an instruction that writes to
+// offset addr of the data segment, DS as it is when SetWriteWatchpoint is
+// called. What is watched is the byte in memory, so a write to it through
+// another segment:offset pair stops Run as well.
+func (c *CPU) SetWriteWatchpoint(addr uint16) {
+	c.writeWatchpoints = append(c.writeWatchpoints, watchpoint{c.DS, addr})
+}
+
+// SetReadWatchpoint is SetWriteWatchpoint for reads. Instruction fetches
+// don't count.
+func (c *CPU) SetReadWatchpoint(addr uint16) {
+	c.readWatchpoints = append(c.readWatchpoints, watchpoint{c.DS, addr})
+}
+
+// ClearWatchpoints removes every read and write watchpoint.
+func (c *CPU) ClearWatchpoints() {
+	c.readWatchpoints = nil
+	c.writeWatchpoints = nil
+}
+
+// watch records a hit when an access of width bytes at seg:off touches one
+// of the watched bytes. Only the first hit of an instruction is kept.
+func (c *CPU) watch(watched []watchpoint, seg, off uint16, width uint8, write bool, v uint16) {
+	if c.watchHit != nil {
+		return
+	}
+	for _, w := range watched {
+		addr := physicalAddress(w.seg, w.off)
+		if addr == physicalAddress(seg, off) || width == 2 && addr == physicalAddress(seg, off+1) {
+			c.watchHit = &WatchpointHit{Seg: w.seg, Addr: w.off, IsWrite: write, Value: v}
+			return
+		}
+	}
+}
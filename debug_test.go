@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBreakpoints(t *testing.T) {
+	c := newTestCPU(t,
+		0xB9, 0x02, 0x00, // mov cx, 2
+		0x40,       // 0103 inc ax
+		0xE2, 0xFD, // loop 0103
+		hlt,
+	)
+	c.SetBreakpoint(0x0103)
+	c.SetBreakpoint(0x0106)
+	c.SetBreakpoint(0x0050)
+
+	if got, want := c.ListBreakpoints(), []uint16{0x0050, 0x0103, 0x0106}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBreakpoints = %04X, want %04X", got, want)
+	}
+	c.ClearBreakpoint(0x0050)
+
+	stops := []struct {
+		addr uint16
+		ax   uint16
+	}{
+		{0x0103, 0},
+		{0x0103, 1},
+		{0x0106, 2},
+	}
+	for i, s := range stops {
+		err := c.Run(100)
+		var hit *BreakpointHit
+		if !errors.As(err, &hit) {
+			t.Fatalf("Run %d = %v, want BreakpointHit", i, err)
+		}
+		if hit.Addr != s.addr || c.IP != s.addr || c.AX != s.ax {
+			t.Errorf("Run %d stopped at %04X IP=%04X AX=%04X, want %04X AX=%04X", i, hit.Addr, c.IP, c.AX, s.addr, s.ax)
+		}
+	}
+
+	err := c.Run(100)
+	if err != nil || !c.Halted {
+		t.Errorf("last Run = %v Halted = %t, want nil true", err, c.Halted)
+	}
+
+	c.ClearAllBreakpoints()
+	if got := c.ListBreakpoints(); len(got) != 0 {
+		t.Errorf("ListBreakpoints after ClearAllBreakpoints = %04X", got)
+	}
+}
+
+func TestBreakpointAtEntry(t *testing.T) {
+	c := newTestCPU(t,
+		0x40,       // 0100 inc ax
+		0x3C, 0x02, // cmp al, 2
+		0x75, 0xFB, // jne 0100
+		hlt,
+	)
+	c.SetBreakpoint(0x0100)
+
+	for i, ax := range []uint16{0, 1} {
+		err := c.Run(100)
+		var hit *BreakpointHit
+		if !errors.As(err, &hit) || hit.Addr != 0x0100 {
+			t.Fatalf("Run %d = %v, want the breakpoint at 0100", i, err)
+		}
+		if c.AX != ax || c.Instructions != uint64(3*i) {
+			t.Errorf("Run %d stopped with AX=%04X after %d instructions, want %04X after %d", i, c.AX, c.Instructions, ax, 3*i)
+		}
+	}
+
+	err := c.Run(100)
+	if err != nil || !c.Halted || c.AX != 2 {
+		t.Errorf("last Run = %v Halted = %t AX = %04X, want nil true 0002", err, c.Halted, c.AX)
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  []byte
+		setup func(c *CPU)
+		want  WatchpointHit
+		ip    uint16 // IP once Run has stopped
+	}{
+		{
+			name:  "write byte",
+			code:  []byte{0x90, 0xA2, 0x00, 0x02, hlt}, // nop; mov [0200h], al
+			setup: func(c *CPU) { c.AX = 0x0042; c.SetWriteWatchpoint(0x0200) },
+			want:  WatchpointHit{Addr: 0x0200, IsWrite: true, Value: 0x42},
+			ip:    0x0104,
+		},
+		{
+			name:  "write the high byte of a word",
+			code:  []byte{0xA3, 0xFF, 0x01, hlt}, // mov [01FFh], ax
+			setup: func(c *CPU) { c.AX = 0xBEEF; c.SetWriteWatchpoint(0x0200) },
+			want:  WatchpointHit{Addr: 0x0200, IsWrite: true, Value: 0xBEEF},
+			ip:    0x0103,
+		},
+		{
+			name: "read",
+			code: []byte{0x8B, 0x07, hlt}, // mov ax, [bx]
+			setup: func(c *CPU) {
+				c.BX = 0x0300
+				c.WriteMemWord(0, 0x0300, 0x1234)
+				c.SetReadWatchpoint(0x0300)
+			},
+			want: WatchpointHit{Addr: 0x0300, Value: 0x1234},
+			ip:   0x0102,
+		},
+		{
+			name:  "push",
+			code:  []byte{0x50, hlt}, // push ax
+			setup: func(c *CPU) { c.AX = 0x5555; c.SetWriteWatchpoint(0xFFFC) },
+			want:  WatchpointHit{Addr: 0xFFFC, IsWrite: true, Value: 0x5555},
+			ip:    0x0101,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCPU(t, tt.code...)
+			tt.setup(c)
+
+			err := c.Run(100)
+			var hit *WatchpointHit
+			if !errors.As(err, &hit) {
+				t.Fatalf("Run = %v, want WatchpointHit", err)
+			}
+			if *hit != tt.want || c.IP != tt.ip {
+				t.Errorf("Run stopped with %+v IP=%04X, want %+v IP=%04X", *hit, c.IP, tt.want, tt.ip)
+			}
+
+			c.ClearWatchpoints()
+			err = c.Run(100)
+			if err != nil || !c.Halted {
+				t.Errorf("Run after ClearWatchpoints = %v Halted = %t, want nil true", err, c.Halted)
+			}
+		})
+	}
+}
+
+func TestWatchpointSegments(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  []byte
+		setup func(c *CPU)
+		hit   bool
+	}{
+		{
+			name:  "push to the same offset in SS",
+			code:  []byte{0x50, hlt}, // push ax
+			setup: func(c *CPU) { c.SP = 0x0202 },
+		},
+		{
+			name:  "string store to the same offset in ES",
+			code:  []byte{0xAA, hlt}, // stosb
+			setup: func(c *CPU) { c.ES, c.DI = 0x2000, 0x0200 },
+		},
+		{
+			name: "vector table read of INT 80h",
+			code: []byte{0xCD, 0x80, hlt}, // int 80h
+			setup: func(c *CPU) {
+				setVector(c, 0x80, 0x0000, 0x0300)
+				c.Memory[0x0300] = 0xCF // iret
+			},
+		},
+		{
+			name:  "the same byte through another segment",
+			code:  []byte{0xA2, 0x00, 0x01, hlt}, // mov [0100h], al
+			setup: func(c *CPU) { c.DS = 0x1010 },
+			hit:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCPU(t, tt.code...)
+			c.DS = 0x1000
+			c.SetReadWatchpoint(0x0200)
+			c.SetWriteWatchpoint(0x0200)
+			tt.setup(c)
+
+			err := c.Run(100)
+			var hit *WatchpointHit
+			if errors.As(err, &hit) != tt.hit {
+				t.Fatalf("Run = %v, want a watchpoint hit %t", err, tt.hit)
+			}
+			if tt.hit && (hit.Seg != 0x1000 || hit.Addr != 0x0200) {
+				t.Errorf("hit at %04X:%04X, want 1000:0200", hit.Seg, hit.Addr)
+			}
+		})
+	}
+}
+
+func TestWatchpointIgnoresFetch(t *testing.T) {
+	c := newTestCPU(t, 0x90, hlt)
+	c.SetReadWatchpoint(0x0100)
+
+	err := c.Run(100)
+	if err != nil {
+		t.Errorf("Run = %v, instruction fetches must not hit a watchpoint", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	reg8Names  = [8]string{"AL", "CL", "DL", "BL", "AH", "CH", "DH", "BH"}
+	reg16Names = [8]string{"AX", "CX", "DX", "BX", "SP", "BP", "SI", "DI"}
+	segNames   = [4]string{"ES", "CS", "SS", "DS"}
+	rmNames    = [8]string{"BX+SI", "BX+DI", "BP+SI", "BP+DI", "SI", "DI", "BP", "BX"}
+)
+
+// Disassemble decodes mem, which is loaded at offset origin, into one line
+// per instruction, e.g. "0100: 8B C3             MOV AX, BX". Bytes that do
+// not start a valid instruction, or start one cut short by the end of mem,
+// come out as "db XX". No CPU is involved.
+func Disassemble(mem []byte, origin uint16) ([]string, error) {
+	if len(mem) > 0x10000-int(origin) {
+		return nil, fmt.Errorf("%d bytes at %04X do not fit in a segment", len(mem), origin)
+	}
+
+	var lines []string
+	for pc := 0; pc < len(mem); {
+		addr := origin + uint16(pc)
+
+		inst, err := decode(func(n uint16) uint8 {
+			if pc+int(n) >= len(mem) {
+				return 0
+			}
+			return mem[pc+int(n)]
+		})
+
+		size := int(inst.Size)
+		var text string
+		if err != nil || pc+size > len(mem) {
+			size = 1
+			text = fmt.Sprintf("db %02X", mem[pc])
+		} else {
+			text = formatInstruction(inst, addr)
+		}
+
+		raw := make([]string, size)
+		for i := range raw {
+			raw[i] = fmt.Sprintf("%02X", mem[pc+i])
+		}
+		lines = append(lines, fmt.Sprintf("%04X: %-17s %s", addr, strings.Join(raw, " "), text))
+
+		pc += size
+	}
+
+	return lines, nil
+}
+
+// formatInstruction renders inst, found at offset addr, in Intel syntax.
+func formatInstruction(inst Instruction, addr uint16) string {
+	var prefix string
+	switch inst.REP {
+	case prefixREPNE:
+		prefix = "REPNE "
+	case prefixREP:
+		prefix = "REP "
+		if strings.HasPrefix(inst.Mnemonic, "CMPS") || strings.HasPrefix(inst.Mnemonic, "SCAS") {
+			prefix = "REPE "
+		}
+	}
+	if inst.SegOverride != 0 && inst.Dst.Kind != OperandMem && inst.Src.Kind != OperandMem {
+		// string instructions and XLAT take the override without a memory operand
+		prefix = segNames[inst.SegOverride>>3&0b11] + ": " + prefix
+	}
+
+	var ops []string
+	if inst.Dst.Kind != OperandNone {
+		ops = append(ops, formatOperand(inst, inst.Dst, addr))
+	}
+	switch {
+	case inst.Opcode == 0xD0 || inst.Opcode == 0xD1:
+		ops = append(ops, "1") // shift count
+	case inst.Opcode == 0xD2 || inst.Opcode == 0xD3:
+		ops = append(ops, "CL")
+	case inst.Src.Kind != OperandNone:
+		ops = append(ops, formatOperand(inst, inst.Src, addr))
+	}
+
+	if len(ops) == 0 {
+		return prefix + inst.Mnemonic
+	}
+	return prefix + inst.Mnemonic + " " + strings.Join(ops, ", ")
+}
+
+// formatOperand renders one operand of inst.
+func formatOperand(inst Instruction, op Operand, addr uint16) string {
+	switch op.Kind {
+	case OperandReg:
+		switch {
+		case inst.Opcode >= 0xEC && inst.Opcode <= 0xEF && op.Reg == 2:
+			return "DX" // port number of IN and OUT
+		case inst.Width == 1:
+			return reg8Names[op.Reg&0b111]
+		}
+		return reg16Names[op.Reg&0b111]
+	case OperandSeg:
+		return segNames[op.Reg&0b11]
+	case OperandMem:
+		return formatMemory(inst)
+	case OperandImm:
+		if inst.Width == 1 {
+			return fmt.Sprintf("0x%02X", inst.Imm)
+		}
+		return fmt.Sprintf("0x%04X", inst.Imm)
+	case OperandRel:
+		return fmt.Sprintf("0x%04X", addr+uint16(inst.Size)+uint16(inst.Disp))
+	case OperandFar:
+		return fmt.Sprintf("0x%04X:0x%04X", inst.Seg, inst.Imm)
+	}
+	return "?"
+}
+
+// formatMemory renders the memory operand of inst, with its size when the
+// other operand does not give it away.
+func formatMemory(inst Instruction) string {
+	var b strings.Builder
+
+	sized := inst.Dst.Kind == OperandReg || inst.Src.Kind == OperandReg ||
+		inst.Dst.Kind == OperandSeg || inst.Src.Kind == OperandSeg
+	if inst.Opcode == 0xD2 || inst.Opcode == 0xD3 {
+		sized = false // CL is only the count
+	}
+
+	switch {
+	case (inst.Mnemonic == "CALL" || inst.Mnemonic == "JMP") && inst.Reg&1 == 1:
+		b.WriteString("FAR ")
+	case sized || inst.Mnemonic == "LEA":
+	case inst.Width == 1:
+		b.WriteString("BYTE ")
+	default:
+		b.WriteString("WORD ")
+	}
+
+	if inst.SegOverride != 0 {
+		b.WriteString(segNames[inst.SegOverride>>3&0b11] + ":")
+	}
+
+	b.WriteString("[")
+	switch {
+	case inst.Mod == 0b00 && inst.RM == 0b110:
+		fmt.Fprintf(&b, "0x%04X", uint16(inst.Disp))
+	case inst.Disp < 0:
+		fmt.Fprintf(&b, "%s-0x%X", rmNames[inst.RM], -int(inst.Disp))
+	case inst.Disp > 0:
+		fmt.Fprintf(&b, "%s+0x%X", rmNames[inst.RM], inst.Disp)
+	default:
+		b.WriteString(rmNames[inst.RM])
+	}
+	b.WriteString("]")
+
+	return b.String()
+}
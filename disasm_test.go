@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		code []byte
+		want string
+	}{
+		{[]byte{0x8B, 0xC3}, "MOV AX, BX"},
+		{[]byte{0x88, 0xE5}, "MOV CH, AH"},
+		{[]byte{0x89, 0x87, 0x34, 0x12}, "MOV [BX+0x1234], AX"},
+		{[]byte{0x8A, 0x46, 0xFE}, "MOV AL, [BP-0x2]"},
+		{[]byte{0xA1, 0x00, 0x02}, "MOV AX, [0x0200]"},
+		{[]byte{0x26, 0x8A, 0x07}, "MOV AL, ES:[BX]"},
+		{[]byte{0xC6, 0x07, 0x05}, "MOV BYTE [BX], 0x05"},
+		{[]byte{0xD1, 0xE0}, "SHL AX, 1"},
+		{[]byte{0xD3, 0x27}, "SHL WORD [BX], CL"},
+		{[]byte{0xEC}, "IN AL, DX"},
+		{[]byte{0xEF}, "OUT DX, AX"},
+		{[]byte{0xE4, 0x60}, "IN AL, 0x60"},
+		{[]byte{0xFE, 0xC2}, "INC DL"},
+		{[]byte{0xFF, 0xC2}, "INC DX"},
+		{[]byte{0xF6, 0xE2}, "MUL DL"},
+		{[]byte{0xF6, 0xDA}, "NEG DL"},
+		{[]byte{0xF7, 0xE2}, "MUL DX"},
+		{[]byte{0xF3, 0xA4}, "REP MOVSB"},
+		{[]byte{0xF3, 0xA6}, "REPE CMPSB"},
+		{[]byte{0xF2, 0xAE}, "REPNE SCASB"},
+		{[]byte{0xEB, 0xFE}, "JMP 0x0100"},
+		{[]byte{0x9A, 0x00, 0x00, 0x00, 0x20}, "CALL 0x2000:0x0000"},
+		{[]byte{0xFF, 0x1F}, "CALL FAR [BX]"},
+		{[]byte{0xF1}, "db F1"},
+		{[]byte{0xB8, 0x34}, "db B8"}, // cut short
+	}
+
+	for _, tt := range tests {
+		lines, err := Disassemble(tt.code, 0x100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := lines[0][len("0100: ")+18:]
+		if got != tt.want {
+			t.Errorf("% X: got %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
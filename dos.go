@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// NewCPUWithDOS returns a CPU with the BIOS services and the DOS INT 20h
+// and INT 21h services installed.
+func NewCPUWithDOS() *CPU {
+	c := NewCPUWithBIOS()
+	c.RegisterInterruptHandler(0x20, dosTerminate)
+	c.RegisterInterruptHandler(0x21, dosServices)
+	return c
+}
+
+// dosTerminate is INT 20h, which ends the program with return code 0. It is
+// what the RET at the end of a COM program reaches through the PSP.
+func dosTerminate(c *CPU) error {
+	c.ExitCode = 0
+	c.Halted = true
+	return nil
+}
+
+// dosServices is INT 21h, the function number is in AH. Functions that are
+// not emulated are logged and otherwise ignored.
+func dosServices(c *CPU) error {
+	switch getAH(c) {
+	case 0x00: // terminate, like INT 20h
+		return dosTerminate(c)
+	case 0x01: // read a character into AL and echo it
+		var b [1]byte
+		_, err := io.ReadFull(c.input, b[:])
+		if errors.Is(err, io.EOF) {
+			b[0] = 0x1A // Ctrl-Z, the DOS end of file
+		} else if err != nil {
+			return err
+		}
+		setAL(c, b[0])
+		_, err = c.output.Write(b[:])
+		return err
+	case 0x02: // display character in DL
+		_, err := c.output.Write([]byte{getDL(c)})
+		return err
+	case 0x09: // print the $ terminated string at DS:DX
+		var s []byte
+		for off := c.DX; ; off++ {
+			b := c.ReadMemByte(c.DS, off)
+			if b == '$' {
+				break
+			}
+			s = append(s, b)
+			if len(s) == 0x10000 {
+				return fmt.Errorf("INT 21h AH=09h: no $ in the segment of the string at %04X:%04X", c.DS, c.DX)
+			}
+		}
+		_, err := c.output.Write(s)
+		return err
+	case 0x4C: // terminate with the return code in AL
+		c.ExitCode = getAL(c)
+		c.Halted = true
+		return nil
+	}
+
+	log.Printf("warning: unsupported DOS function INT 21h AH=%02Xh", getAH(c))
+	return nil
+}
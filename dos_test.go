@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCOM writes code to a COM file in a temporary directory and returns
+// its name.
+func writeCOM(t *testing.T, code ...byte) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.com")
+	err := os.WriteFile(name, code, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestHelloWorld(t *testing.T) {
+	c := NewCPUWithDOS()
+	var out bytes.Buffer
+	c.SetOutput(&out)
+
+	err := c.LoadCOMFile("fixtures/hello.bin", 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Run(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "Hello, World!" {
+		t.Errorf("output = %q, want %q", out.String(), "Hello, World!")
+	}
+	if !c.Halted || c.ExitCode != 0 {
+		t.Errorf("Halted = %t ExitCode = %d, want true 0", c.Halted, c.ExitCode)
+	}
+}
+
+func TestDOSPrintStringUnterminated(t *testing.T) {
+	c := NewCPUWithDOS()
+	c.SetOutput(&bytes.Buffer{})
+	err := c.LoadCOMFile(writeCOM(t, 0xB4, 0x09, 0xCD, 0x21, 0xF4), 0x1000) // mov ah, 9; int 21h; hlt
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < 0x10000; off++ {
+		if c.Memory[0x10000+off] == '$' {
+			c.Memory[0x10000+off] = 0
+		}
+	}
+
+	err = c.Run(0)
+	if err == nil {
+		t.Fatal("Run succeeded without a $ in the segment")
+	}
+}
+
+func TestDOSTerminate(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		exit uint8
+	}{
+		{"RET through the PSP", []byte{0xB8, 0x05, 0x4C, 0xC3}, 0},               // mov ax, 4C05h; ret
+		{"INT 20h", []byte{0xB0, 0x05, 0xCD, 0x20, 0xF4}, 0},                     // mov al, 5; int 20h
+		{"INT 21h AH=00h", []byte{0xB8, 0x05, 0x00, 0xCD, 0x21, 0xF4}, 0},        // mov ax, 0005h; int 21h
+		{"INT 21h AH=4Ch", []byte{0xB8, 0x05, 0x4C, 0xCD, 0x21, 0xF4}, 5},        // mov ax, 4C05h; int 21h
+		{"RET after output", []byte{0xB4, 0x02, 0xB2, 'x', 0xCD, 0x21, 0xC3}, 0}, // mov ah, 2; mov dl, 'x'; int 21h; ret
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCPUWithDOS()
+			c.SetOutput(&bytes.Buffer{})
+			c.MaxInstructions = 100
+
+			err := c.LoadCOMFile(writeCOM(t, tt.code...), 0x1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.Run(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.Halted {
+				t.Fatal("program did not terminate")
+			}
+			if c.ExitCode != tt.exit {
+				t.Errorf("ExitCode = %d, want %d", c.ExitCode, tt.exit)
+			}
+		})
+	}
+}
+
+func TestDOSReadCharacter(t *testing.T) {
+	c := NewCPUWithDOS()
+	var out bytes.Buffer
+	c.SetOutput(&out)
+	c.SetInput(strings.NewReader("ab"))
+
+	err := c.LoadCOMFile(writeCOM(t,
+		0xB4, 0x01, // mov ah, 1
+		0xCD, 0x21, // int 21h
+		0x88, 0xC3, // mov bl, al
+		0xCD, 0x21, // int 21h
+		0x88, 0xC7, // mov bh, al
+		0xCD, 0x21, // int 21h, at the end of the input
+		0xB4, 0x4C, // mov ah, 4Ch
+		0xCD, 0x21, // int 21h
+	), 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BX != 'b'<<8|'a' || c.ExitCode != 0x1A {
+		t.Errorf("BX = %04X ExitCode = %02X, want 6261 1A", c.BX, c.ExitCode)
+	}
+	if out.String() != "ab\x1a" {
+		t.Errorf("echoed %q, want %q", out.String(), "ab\x1a")
+	}
+}
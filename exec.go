@@ -0,0 +1,603 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DivideError reports a DIV or IDIV that divides by zero or whose quotient
+// does not fit in the destination register. The CPU turns it into INT 0.
+type DivideError struct {
+	Dividend uint32
+	Divisor  uint16
+}
+
+func (e *DivideError) Error() string {
+	return fmt.Sprintf("divide error: %X / %X", e.Dividend, e.Divisor)
+}
+
+// divideError raises INT 0, for DIV and IDIV, when err is a *DivideError and passes any other
+// error through. Like on the 8086, the saved IP points past the DIV.
+func (c *CPU) divideError(err error) error {
+	var de *DivideError
+	if errors.As(err, &de) {
+		return c.interrupt(0)
+	}
+	return err
+}
+
+// getReg8 returns the byte register selected by a REG or R/M field.
+func getReg8(c *CPU, reg uint8) uint8 {
+	switch reg & 0b111 {
+	case 0b000:
+		return getAL(c)
+	case 0b001:
+		return getCL(c)
+	case 0b010:
+		return getDL(c)
+	case 0b011:
+		return getBL(c)
+	case 0b100:
+		return getAH(c)
+	case 0b101:
+		return getCH(c)
+	case 0b110:
+		return getDH(c)
+	}
+	return getBH(c)
+}
+
+// setReg8 writes the byte register selected by a REG or R/M field.
+func setReg8(c *CPU, reg uint8, v uint8) {
+	switch reg & 0b111 {
+	case 0b000:
+		setAL(c, v)
+	case 0b001:
+		setCL(c, v)
+	case 0b010:
+		setDL(c, v)
+	case 0b011:
+		setBL(c, v)
+	case 0b100:
+		setAH(c, v)
+	case 0b101:
+		setCH(c, v)
+	case 0b110:
+		setDH(c, v)
+	default:
+		setBH(c, v)
+	}
+}
+
+// reg16 returns the word register selected by a REG or R/M field.
+func reg16(c *CPU, reg uint8) *uint16 {
+	switch reg & 0b111 {
+	case 0b000:
+		return &c.AX
+	case 0b001:
+		return &c.CX
+	case 0b010:
+		return &c.DX
+	case 0b011:
+		return &c.BX
+	case 0b100:
+		return &c.SP
+	case 0b101:
+		return &c.BP
+	case 0b110:
+		return &c.SI
+	}
+	return &c.DI
+}
+
+// segReg returns the segment register selected by a SR field.
+func segReg(c *CPU, sreg uint8) *uint16 {
+	switch sreg & 0b11 {
+	case 0b00:
+		return &c.ES
+	case 0b01:
+		return &c.CS
+	case 0b10:
+		return &c.SS
+	}
+	return &c.DS
+}
+
+// operandAddr returns the segment and offset of the memory operand of inst,
+// which always comes with mod != 11.
+func (c *CPU) operandAddr(inst Instruction) (uint16, uint16) {
+	return EffectiveAddress(c, inst.Mod, inst.RM, uint16(inst.Disp))
+}
+
+func (c *CPU) readOperand(inst Instruction, op Operand) uint16 {
+	switch op.Kind {
+	case OperandReg:
+		if inst.Width == 1 {
+			return uint16(getReg8(c, op.Reg))
+		}
+		return *reg16(c, op.Reg)
+	case OperandSeg:
+		return *segReg(c, op.Reg)
+	case OperandMem:
+		seg, off := c.operandAddr(inst)
+		return c.readMem(seg, off, inst.Width)
+	case OperandImm:
+		return inst.Imm
+	}
+	return 0
+}
+
+func (c *CPU) writeOperand(inst Instruction, op Operand, v uint16) {
+	switch op.Kind {
+	case OperandReg:
+		if inst.Width == 1 {
+			setReg8(c, op.Reg, uint8(v))
+			return
+		}
+		*reg16(c, op.Reg) = v
+	case OperandSeg:
+		*segReg(c, op.Reg) = v
+	case OperandMem:
+		seg, off := c.operandAddr(inst)
+		c.writeMem(seg, off, inst.Width, v)
+	}
+}
+
+// execute runs an already decoded instruction, IP must already point to the
+// next one.
+func (c *CPU) execute(inst Instruction) error {
+	if inst.SegOverride != 0 {
+		c.segmentOverride = segReg(c, inst.SegOverride>>3)
+		defer func() { c.segmentOverride = nil }()
+	}
+
+	switch inst.Mnemonic {
+	case "NOP":
+		return nil
+	case "HLT":
+		c.Halted = true
+		return nil
+	case "CLC", "STC":
+		c.SetFlag(FlagCF, inst.Mnemonic == "STC")
+		return nil
+	case "CMC":
+		c.SetFlag(FlagCF, !c.GetFlag(FlagCF))
+		return nil
+	case "CLI", "STI":
+		c.SetFlag(FlagIF, inst.Mnemonic == "STI")
+		return nil
+	case "CLD", "STD":
+		c.SetFlag(FlagDF, inst.Mnemonic == "STD")
+		return nil
+	case "MOV":
+		c.execMOV(inst)
+		return nil
+	case "ADD":
+		c.execADD(inst, 0)
+		return nil
+	case "ADC":
+		var carry uint16
+		if c.GetFlag(FlagCF) {
+			carry = 1
+		}
+		c.execADD(inst, carry)
+		return nil
+	case "SUB":
+		c.execSUB(inst, 0)
+		return nil
+	case "SBB":
+		var borrow uint16
+		if c.GetFlag(FlagCF) {
+			borrow = 1
+		}
+		c.execSUB(inst, borrow)
+		return nil
+	case "CMP":
+		// a SUB that only keeps the flags
+		c.sub(c.readOperand(inst, inst.Dst), c.readOperand(inst, inst.Src), 0, inst.Width)
+		return nil
+	case "JO", "JNO", "JB", "JAE", "JE", "JNE", "JBE", "JA",
+		"JS", "JNS", "JP", "JNP", "JL", "JGE", "JLE", "JG":
+		if condMet(c, inst.Opcode&0x0F) {
+			c.IP += uint16(inst.Disp)
+			return nil
+		}
+		c.chargeInstead(inst, 4)
+		return nil
+	case "PUSH":
+		v := c.readOperand(inst, inst.Src)
+		if inst.Src.Kind == OperandReg && inst.Src.Reg == 0b100 {
+			v -= 2 // PUSH SP stores the already decremented SP on the 8086
+		}
+		pushWord(c, v)
+		return nil
+	case "POP":
+		c.writeOperand(inst, inst.Dst, popWord(c))
+		return nil
+	case "PUSHF":
+		pushWord(c, c.FL)
+		return nil
+	case "POPF":
+		c.FL = popWord(c)&flagsMask | flagsFixed
+		return nil
+	case "DAA":
+		c.daa()
+		return nil
+	case "DAS":
+		c.das()
+		return nil
+	case "AAA":
+		c.aaa()
+		return nil
+	case "AAS":
+		c.aas()
+		return nil
+	case "AAM":
+		return c.divideError(c.aam(uint8(inst.Imm)))
+	case "AAD":
+		c.aad(uint8(inst.Imm))
+		return nil
+	case "CBW":
+		c.AX = uint16(int8(getAL(c)))
+		return nil
+	case "CWD":
+		c.DX = uint16(int16(c.AX) >> 15)
+		return nil
+	case "SAHF":
+		// only SF, ZF, AF, PF and CF live in the low byte
+		c.FL = c.FL&0xFF00 | uint16(getAH(c))&flagsMask | flagsFixed
+		return nil
+	case "LAHF":
+		setAH(c, uint8(c.FL))
+		return nil
+	case "JMP":
+		return c.execJMP(inst)
+	case "CALL":
+		return c.execCALL(inst)
+	case "RET":
+		c.IP = popWord(c)
+		c.SP += inst.Imm // RET imm16 drops the arguments
+		return nil
+	case "RETF":
+		c.IP = popWord(c)
+		c.CS = popWord(c)
+		c.SP += inst.Imm
+		return nil
+	case "INT":
+		return c.interrupt(uint8(inst.Imm))
+	case "INTO":
+		if c.GetFlag(FlagOF) {
+			return c.interrupt(4)
+		}
+		c.chargeInstead(inst, 4)
+		return nil
+	case "IRET":
+		c.iret()
+		return nil
+	case "LOOP", "LOOPE", "LOOPNE":
+		c.CX-- // no flags are touched
+		taken := c.CX != 0
+		switch inst.Mnemonic {
+		case "LOOPE":
+			taken = taken && c.GetFlag(FlagZF)
+		case "LOOPNE":
+			taken = taken && !c.GetFlag(FlagZF)
+		}
+		if taken {
+			c.IP += uint16(inst.Disp)
+			return nil
+		}
+		c.chargeInstead(inst, [3]uint8{5, 6, 5}[inst.Opcode-0xE0])
+		return nil
+	case "JCXZ":
+		if c.CX == 0 {
+			c.IP += uint16(inst.Disp)
+			return nil
+		}
+		c.chargeInstead(inst, 6)
+		return nil
+	case "MOVSB", "MOVSW", "CMPSB", "CMPSW", "SCASB", "SCASW",
+		"LODSB", "LODSW", "STOSB", "STOSW":
+		c.execString(inst)
+		return nil
+	case "INC", "DEC":
+		c.execINCDEC(inst)
+		return nil
+	case "AND", "OR", "XOR", "TEST":
+		c.execLogical(inst)
+		return nil
+	case "ROL", "ROR", "RCL", "RCR", "SHL", "SHR", "SAR":
+		count := uint8(inst.Imm)
+		if inst.Src.Kind == OperandReg {
+			count = getCL(c)
+			c.Cycles += 4 * uint64(count) // 4 per bit shifted
+		}
+		v := c.readOperand(inst, inst.Dst)
+		shiftRotate(c, inst.Reg, &v, count, inst.W)
+		c.writeOperand(inst, inst.Dst, v)
+		return nil
+	case "XCHG":
+		// Nothing runs between the read and the writes, so the exchange is
+		// atomic here. The real chip asserts LOCK for XCHG with memory, bus
+		// locking is not emulated.
+		a := c.readOperand(inst, inst.Dst)
+		b := c.readOperand(inst, inst.Src)
+		c.writeOperand(inst, inst.Dst, b)
+		c.writeOperand(inst, inst.Src, a)
+		return nil
+	case "IN", "OUT":
+		c.execIO(inst)
+		return nil
+	case "XLAT":
+		setAL(c, c.ReadMemByte(c.dataSegment(), c.BX+uint16(getAL(c))))
+		return nil
+	case "LEA":
+		if inst.Src.Kind != OperandMem {
+			return fmt.Errorf("LEA needs a memory operand")
+		}
+		_, offset := c.operandAddr(inst)
+		*reg16(c, inst.Reg) = offset
+		return nil
+	case "LDS", "LES":
+		if inst.Src.Kind != OperandMem {
+			return fmt.Errorf("%s needs a memory operand", inst.Mnemonic)
+		}
+		// the offset comes first, then the segment
+		seg, off := c.operandAddr(inst)
+		dst := &c.DS
+		if inst.Mnemonic == "LES" {
+			dst = &c.ES
+		}
+		*reg16(c, inst.Reg) = c.ReadMemWord(seg, off)
+		*dst = c.ReadMemWord(seg, off+2)
+		return nil
+	case "NOT":
+		c.writeOperand(inst, inst.Dst, ^c.readOperand(inst, inst.Dst))
+		return nil
+	case "NEG":
+		// same as subtracting from zero, so CF ends up set unless the
+		// operand was zero
+		v := c.readOperand(inst, inst.Dst)
+		c.writeOperand(inst, inst.Dst, c.sub(0, v, 0, inst.Width))
+		return nil
+	case "MUL":
+		c.execMUL(inst)
+		return nil
+	case "IMUL":
+		c.execIMUL(inst)
+		return nil
+	case "DIV":
+		return c.divideError(c.execDIV(inst))
+	case "IDIV":
+		return c.divideError(c.execIDIV(inst))
+	}
+
+	return fmt.Errorf("unimplemented instruction: %s", inst.Mnemonic)
+}
+
+func (c *CPU) execMOV(inst Instruction) {
+	c.writeOperand(inst, inst.Dst, c.readOperand(inst, inst.Src))
+}
+
+func (c *CPU) execADD(inst Instruction, carry uint16) {
+	a := c.readOperand(inst, inst.Dst)
+	b := c.readOperand(inst, inst.Src)
+	c.writeOperand(inst, inst.Dst, c.add(a, b, carry, inst.Width))
+}
+
+func (c *CPU) execSUB(inst Instruction, borrow uint16) {
+	a := c.readOperand(inst, inst.Dst)
+	b := c.readOperand(inst, inst.Src)
+	c.writeOperand(inst, inst.Dst, c.sub(a, b, borrow, inst.Width))
+}
+
+func (c *CPU) execJMP(inst Instruction) error {
+	switch {
+	case inst.Dst.Kind == OperandRel:
+		c.IP += uint16(inst.Disp)
+	case inst.Dst.Kind == OperandFar:
+		c.CS, c.IP = inst.Seg, inst.Imm
+	case inst.Reg == 4: // near indirect
+		c.IP = c.readOperand(inst, inst.Dst)
+	case inst.Dst.Kind == OperandMem: // far indirect, offset then segment
+		seg, off := c.operandAddr(inst)
+		c.IP = c.ReadMemWord(seg, off)
+		c.CS = c.ReadMemWord(seg, off+2)
+	default:
+		return fmt.Errorf("invalid operand for far JMP")
+	}
+	return nil
+}
+
+// execCALL pushes the return address, CS first for far calls, and jumps like
+// execJMP does.
+func (c *CPU) execCALL(inst Instruction) error {
+	switch {
+	case inst.Dst.Kind == OperandRel:
+		pushWord(c, c.IP)
+		c.IP += uint16(inst.Disp)
+	case inst.Dst.Kind == OperandFar:
+		pushWord(c, c.CS)
+		pushWord(c, c.IP)
+		c.CS, c.IP = inst.Seg, inst.Imm
+	case inst.Reg == 2: // near indirect
+		target := c.readOperand(inst, inst.Dst)
+		pushWord(c, c.IP)
+		c.IP = target
+	case inst.Dst.Kind == OperandMem: // far indirect, offset then segment
+		seg, off := c.operandAddr(inst)
+		ip, cs := c.ReadMemWord(seg, off), c.ReadMemWord(seg, off+2)
+		pushWord(c, c.CS)
+		pushWord(c, c.IP)
+		c.CS, c.IP = cs, ip
+	default:
+		return fmt.Errorf("invalid operand for far CALL")
+	}
+	return nil
+}
+
+// condMet tests the condition encoded in the low nibble of a Jcc opcode.
+// Conditions come in pairs, an odd cond is the negation of the one before.
+func condMet(c *CPU, cond uint8) bool {
+	var met bool
+	switch cond >> 1 {
+	case 0: // O
+		met = c.GetFlag(FlagOF)
+	case 1: // B, C, NAE
+		met = c.GetFlag(FlagCF)
+	case 2: // E, Z
+		met = c.GetFlag(FlagZF)
+	case 3: // BE, NA
+		met = c.GetFlag(FlagCF) || c.GetFlag(FlagZF)
+	case 4: // S
+		met = c.GetFlag(FlagSF)
+	case 5: // P, PE
+		met = c.GetFlag(FlagPF)
+	case 6: // L, NGE
+		met = c.GetFlag(FlagSF) != c.GetFlag(FlagOF)
+	case 7: // LE, NG
+		met = c.GetFlag(FlagZF) || c.GetFlag(FlagSF) != c.GetFlag(FlagOF)
+	}
+
+	if cond&1 == 1 {
+		return !met
+	}
+	return met
+}
+
+// execINCDEC adds or subtracts one. Unlike ADD and SUB, INC and DEC leave CF
+// untouched.
+func (c *CPU) execINCDEC(inst Instruction) {
+	cf := c.GetFlag(FlagCF)
+
+	v := c.readOperand(inst, inst.Dst)
+	if inst.Mnemonic == "INC" {
+		v = c.add(v, 1, 0, inst.Width)
+	} else {
+		v = c.sub(v, 1, 0, inst.Width)
+	}
+	c.writeOperand(inst, inst.Dst, v)
+
+	c.SetFlag(FlagCF, cf)
+}
+
+// execLogical runs the bitwise instructions, TEST is an AND that only keeps
+// the flags.
+func (c *CPU) execLogical(inst Instruction) {
+	a := c.readOperand(inst, inst.Dst)
+	b := c.readOperand(inst, inst.Src)
+
+	var r uint16
+	switch inst.Mnemonic {
+	case "AND", "TEST":
+		r = a & b
+	case "OR":
+		r = a | b
+	case "XOR":
+		r = a ^ b
+	}
+
+	c.logicalFlags(r, inst.Width)
+	if inst.Mnemonic != "TEST" {
+		c.writeOperand(inst, inst.Dst, r)
+	}
+}
+
+// execMUL multiplies the accumulator by the operand, unsigned. Byte results go
+// to AX and word results to DX:AX, CF and OF tell whether the high half is in
+// use.
+func (c *CPU) execMUL(inst Instruction) {
+	src := c.readOperand(inst, inst.Src)
+
+	if inst.Width == 1 {
+		c.AX = uint16(getAL(c)) * src
+		c.SetFlag(FlagCF, getAH(c) != 0)
+		c.SetFlag(FlagOF, getAH(c) != 0)
+		return
+	}
+
+	r := uint32(c.AX) * uint32(src)
+	c.AX = uint16(r)
+	c.DX = uint16(r >> 16)
+	c.SetFlag(FlagCF, c.DX != 0)
+	c.SetFlag(FlagOF, c.DX != 0)
+}
+
+// execIMUL is the signed form of execMUL, CF and OF are set when the high
+// half is more than the sign extension of the low half.
+func (c *CPU) execIMUL(inst Instruction) {
+	src := c.readOperand(inst, inst.Src)
+
+	if inst.Width == 1 {
+		c.AX = uint16(int16(int8(getAL(c))) * int16(int8(src)))
+		overflow := int16(c.AX) != int16(int8(getAL(c)))
+		c.SetFlag(FlagCF, overflow)
+		c.SetFlag(FlagOF, overflow)
+		return
+	}
+
+	r := int32(int16(c.AX)) * int32(int16(src))
+	c.AX = uint16(r)
+	c.DX = uint16(r >> 16)
+	overflow := r != int32(int16(c.AX))
+	c.SetFlag(FlagCF, overflow)
+	c.SetFlag(FlagOF, overflow)
+}
+
+// execDIV divides AX, or DX:AX for words, by the operand, unsigned. The
+// quotient goes to AL (AX) and the remainder to AH (DX).
+func (c *CPU) execDIV(inst Instruction) error {
+	src := c.readOperand(inst, inst.Src)
+
+	if inst.Width == 1 {
+		if src == 0 || c.AX/src > 0xFF {
+			return &DivideError{Dividend: uint32(c.AX), Divisor: src}
+		}
+		q, r := c.AX/src, c.AX%src
+		setAL(c, uint8(q))
+		setAH(c, uint8(r))
+		return nil
+	}
+
+	dividend := uint32(c.DX)<<16 | uint32(c.AX)
+	if src == 0 || dividend/uint32(src) > 0xFFFF {
+		return &DivideError{Dividend: dividend, Divisor: src}
+	}
+	c.AX = uint16(dividend / uint32(src))
+	c.DX = uint16(dividend % uint32(src))
+	return nil
+}
+
+// execIDIV is the signed form of execDIV. The quotient is truncated toward
+// zero and the remainder takes the sign of the dividend. Like the original
+// 8086, a quotient of -128 (-32768 for words) is also a divide error.
+func (c *CPU) execIDIV(inst Instruction) error {
+	src := c.readOperand(inst, inst.Src)
+
+	if inst.Width == 1 {
+		divisor := int16(int8(src))
+		if divisor == 0 {
+			return &DivideError{Dividend: uint32(c.AX), Divisor: src}
+		}
+		q, r := int16(c.AX)/divisor, int16(c.AX)%divisor
+		if q > 0x7F || q < -0x7F {
+			return &DivideError{Dividend: uint32(c.AX), Divisor: src}
+		}
+		setAL(c, uint8(q))
+		setAH(c, uint8(r))
+		return nil
+	}
+
+	dividend := uint32(c.DX)<<16 | uint32(c.AX)
+	divisor := int32(int16(src))
+	if divisor == 0 {
+		return &DivideError{Dividend: dividend, Divisor: src}
+	}
+	q, r := int32(dividend)/divisor, int32(dividend)%divisor
+	if q > 0x7FFF || q < -0x7FFF {
+		return &DivideError{Dividend: dividend, Divisor: src}
+	}
+	c.AX = uint16(q)
+	c.DX = uint16(r)
+	return nil
+}
@@ -0,0 +1,469 @@
+package main
+
+import "testing"
+
+func TestMOV(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "MOV BX, AX", code: []byte{0x89, 0xC3, hlt},
+			setup: func(c *CPU) { c.AX = 0x1234 },
+			regs:  map[string]uint16{"AX": 0x1234, "BX": 0x1234},
+		},
+		{
+			name: "MOV CH, AH", code: []byte{0x88, 0xE5, hlt},
+			setup: func(c *CPU) { c.AX, c.CX = 0xAB00, 0x0012 },
+			regs:  map[string]uint16{"CX": 0xAB12},
+		},
+		{
+			name: "MOV AX, 1234h and MOV DL, ABh", code: []byte{0xB8, 0x34, 0x12, 0xB2, 0xAB, hlt},
+			setup: func(c *CPU) { c.DX = 0x5500 },
+			regs:  map[string]uint16{"AX": 0x1234, "DX": 0x55AB},
+		},
+		{
+			name: "MOV [0200h], AX and MOV AL, [0201h]", code: []byte{0xA3, 0x00, 0x02, 0xA0, 0x01, 0x02, hlt},
+			setup: func(c *CPU) { c.AX = 0x1234 },
+			regs:  map[string]uint16{"AX": 0x1212},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x1234) },
+		},
+		{
+			name: "MOV AX, [BX+SI+4]", code: []byte{0x8B, 0x40, 0x04, hlt},
+			setup: func(c *CPU) { c.BX, c.SI = 0x0200, 0x0010; c.WriteMemWord(0, 0x0214, 0xBEEF) },
+			regs:  map[string]uint16{"AX": 0xBEEF},
+		},
+		{
+			name: "MOV AL, [BP] uses SS", code: []byte{0x8A, 0x46, 0x00, hlt},
+			setup: func(c *CPU) { c.BP, c.SS = 0x0010, 0x1000; c.Memory[0x10010] = 0x42 },
+			regs:  map[string]uint16{"AX": 0x0042},
+		},
+		{
+			name: "MOV BYTE [BX], 7", code: []byte{0xC6, 0x07, 0x07, hlt},
+			setup: func(c *CPU) { c.BX = 0x0200; c.Memory[0x0201] = 0x99 },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x9907) },
+		},
+		{
+			name: "MOV WORD [SI+2], BEEFh", code: []byte{0xC7, 0x44, 0x02, 0xEF, 0xBE, hlt},
+			setup: func(c *CPU) { c.SI = 0x0200 },
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0202, 2, 0xBEEF) },
+		},
+		{
+			name: "MOV DS, AX and MOV DX, DS", code: []byte{0x8E, 0xD8, 0x8C, 0xDA, hlt},
+			setup: func(c *CPU) { c.AX = 0x2000 },
+			regs:  map[string]uint16{"DS": 0x2000, "DX": 0x2000},
+		},
+		{
+			name: "MOV leaves the flags", code: []byte{0xF9, 0xB8, 0x00, 0x00, hlt},
+			setup: func(c *CPU) { c.AX = 0x1234 },
+			regs:  map[string]uint16{"AX": 0x0000},
+			set:   FlagCF, clear: FlagZF,
+		},
+	})
+}
+
+func TestXCHG(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "XCHG AX, BX short form", code: []byte{0xF9, 0x93, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x1111, 0x2222 },
+			regs:  map[string]uint16{"AX": 0x2222, "BX": 0x1111},
+			set:   FlagCF,
+		},
+		{
+			name: "XCHG BX, AX r/m form", code: []byte{0x87, 0xD8, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x1111, 0x2222 },
+			regs:  map[string]uint16{"AX": 0x2222, "BX": 0x1111},
+		},
+		{
+			name: "XCHG AL, [BX]", code: []byte{0x86, 0x07, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x1111, 0x0200; c.Memory[0x0200] = 0x99 },
+			regs:  map[string]uint16{"AX": 0x1199},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 1, 0x11) },
+		},
+	})
+}
+
+func TestXLAT(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "XLAT", code: []byte{0xD7, hlt},
+			setup: func(c *CPU) {
+				c.BX, c.AX = 0x0200, 0x000C
+				copy(c.Memory[0x0200:], "0123456789ABCDEF")
+			},
+			regs: map[string]uint16{"AX": 'C'},
+		},
+	})
+}
+
+func TestLEA(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "LEA BX, [SI+10h]", code: []byte{0x8D, 0x5C, 0x10, hlt},
+			setup: func(c *CPU) { c.SI = 0x0100; c.Memory[0x0110] = 0x55 },
+			regs:  map[string]uint16{"BX": 0x0110},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0110, 1, 0x55) },
+		},
+		{
+			name: "LEA BX, [SI+4]", code: []byte{0x8D, 0x5C, 0x04, hlt},
+			setup: func(c *CPU) { c.SI = 0x0100 },
+			regs:  map[string]uint16{"BX": 0x0104},
+		},
+		{
+			name: "LEA DI, [BX+SI]", code: []byte{0x8D, 0x38, hlt},
+			setup: func(c *CPU) { c.BX, c.SI = 0x1000, 0x0234 },
+			regs:  map[string]uint16{"DI": 0x1234},
+		},
+	})
+}
+
+func TestLDSLES(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "LDS BX, [0200h]", code: []byte{0xC5, 0x1E, 0x00, 0x02, hlt},
+			setup: func(c *CPU) { copy(c.Memory[0x0200:], []byte{0x34, 0x12, 0x00, 0x30}) },
+			regs:  map[string]uint16{"BX": 0x1234, "DS": 0x3000},
+		},
+		{
+			name: "LES DI, [BX]", code: []byte{0xC4, 0x3F, hlt},
+			setup: func(c *CPU) { c.BX = 0x0200; copy(c.Memory[0x0200:], []byte{0x78, 0x56, 0x00, 0x40}) },
+			regs:  map[string]uint16{"DI": 0x5678, "ES": 0x4000, "DS": 0x0000},
+		},
+	})
+}
+
+func TestPushPop(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "PUSH AX, PUSH BX, POP DX, POP CX", code: []byte{0x50, 0x53, 0x5A, 0x59, hlt},
+			setup: func(c *CPU) { c.AX, c.BX = 0x1111, 0x2222 },
+			regs:  map[string]uint16{"DX": 0x2222, "CX": 0x1111, "SP": 0xFFFE},
+			check: func(t *testing.T, c *CPU) {
+				wantMem(t, c, 0xFFFC, 2, 0x1111)
+				wantMem(t, c, 0xFFFA, 2, 0x2222)
+			},
+		},
+		{
+			name: "PUSH all registers",
+			code: []byte{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, hlt},
+			setup: func(c *CPU) {
+				c.AX, c.CX, c.DX, c.BX = 0x1111, 0x2222, 0x3333, 0x4444
+				c.BP, c.SI, c.DI = 0x6666, 0x7777, 0x8888
+			},
+			regs: map[string]uint16{"SP": 0xFFEE},
+			check: func(t *testing.T, c *CPU) {
+				want := []uint16{0x1111, 0x2222, 0x3333, 0x4444, 0xFFF4, 0x6666, 0x7777, 0x8888}
+				for i, w := range want {
+					wantMem(t, c, uint32(0xFFFC-2*i), 2, w) // PUSH SP stores SP after the decrement
+				}
+			},
+		},
+		{
+			name: "PUSH DS, POP ES", code: []byte{0x1E, 0x07, hlt},
+			setup: func(c *CPU) { c.DS = 0x1234 },
+			regs:  map[string]uint16{"ES": 0x1234, "SP": 0xFFFE},
+		},
+		{
+			name: "PUSH [BX], POP [DI]", code: []byte{0xFF, 0x37, 0x8F, 0x05, hlt},
+			setup: func(c *CPU) { c.BX, c.DI = 0x0200, 0x0300; c.WriteMemWord(0, 0x0200, 0xBEEF) },
+			regs:  map[string]uint16{"SP": 0xFFFE},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0300, 2, 0xBEEF) },
+		},
+	})
+}
+
+func TestPUSHFPOPF(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "PUSHF, clobber, POPF", code: []byte{0x9C, 0x31, 0xC0, 0x9D, hlt},
+			setup: func(c *CPU) { c.FL = 0xFFD7 }, // every flag set
+			regs:  map[string]uint16{"FL": 0xFFD7, "AX": 0x0000},
+		},
+	})
+}
+
+func TestLAHFSAHF(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "LAHF and SAHF", code: []byte{0xFD, 0x04, 0x01, 0x9F, 0x88, 0xE3, 0xB4, 0x80, 0x9E, hlt},
+			// std; add al, 1; lahf; mov bl, ah; mov ah, 80h; sahf
+			setup: func(c *CPU) { c.AX = 0x00FF },
+			regs:  map[string]uint16{"BX": 0x0057},
+			set:   FlagSF | FlagDF, clear: FlagCF | FlagZF | FlagAF | FlagPF,
+		},
+	})
+}
+
+func TestJumps(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "JMP short forward", code: []byte{0xEB, 0x01, 0x40, hlt},
+			regs: map[string]uint16{"AX": 0x0000},
+		},
+		{
+			name: "JMP near", code: []byte{0xE9, 0x01, 0x00, 0x40, hlt},
+			regs: map[string]uint16{"AX": 0x0000},
+		},
+		{
+			name: "JMP short backward",
+			code: []byte{
+				0xB9, 0x03, 0x00, // mov cx, 3
+				0x49,       // dec cx
+				0x74, 0x02, // jz +2
+				0xEB, 0xFB, // jmp -5
+				hlt,
+			},
+			regs: map[string]uint16{"CX": 0x0000},
+		},
+		{
+			name: "JMP far", code: []byte{0xEA, 0x00, 0x00, 0x00, 0x20},
+			setup: func(c *CPU) { c.Memory[0x20000] = hlt },
+			regs:  map[string]uint16{"CS": 0x2000, "IP": 0x0001},
+		},
+		{
+			name: "JMP AX", code: []byte{0xFF, 0xE0, 0x40, 0x40, 0x40, hlt},
+			setup: func(c *CPU) { c.AX = 0x0105 },
+			regs:  map[string]uint16{"AX": 0x0105},
+		},
+		{
+			name: "JMP FAR [BX]", code: []byte{0xFF, 0x2F},
+			setup: func(c *CPU) {
+				c.BX = 0x0200
+				copy(c.Memory[0x0200:], []byte{0x10, 0x00, 0x00, 0x30})
+				c.Memory[0x30010] = hlt
+			},
+			regs: map[string]uint16{"CS": 0x3000, "IP": 0x0011},
+		},
+		{
+			name: "JNE backward",
+			code: []byte{
+				0xB9, 0x02, 0x00, // mov cx, 2
+				0x40,       // inc ax
+				0x49,       // dec cx
+				0x75, 0xFC, // jne -4
+				hlt,
+			},
+			regs: map[string]uint16{"AX": 0x0002, "CX": 0x0000},
+		},
+	})
+}
+
+func TestConditionalJumps(t *testing.T) {
+	tests := []struct {
+		opcode uint8
+		flags  uint16
+		taken  bool
+	}{
+		{0x70, FlagOF, true}, // JO
+		{0x70, 0, false},
+		{0x71, 0, true}, // JNO
+		{0x71, FlagOF, false},
+		{0x72, FlagCF, true}, // JB
+		{0x72, 0, false},
+		{0x73, 0, true}, // JAE
+		{0x73, FlagCF, false},
+		{0x74, FlagZF, true}, // JE
+		{0x74, 0, false},
+		{0x75, 0, true}, // JNE
+		{0x75, FlagZF, false},
+		{0x76, FlagZF, true}, // JBE
+		{0x76, FlagCF, true},
+		{0x76, 0, false},
+		{0x77, 0, true}, // JA
+		{0x77, FlagCF, false},
+		{0x77, FlagZF, false},
+		{0x78, FlagSF, true}, // JS
+		{0x78, 0, false},
+		{0x79, 0, true}, // JNS
+		{0x79, FlagSF, false},
+		{0x7A, FlagPF, true}, // JP
+		{0x7A, 0, false},
+		{0x7B, 0, true}, // JNP
+		{0x7B, FlagPF, false},
+		{0x7C, FlagSF, true}, // JL
+		{0x7C, FlagOF, true},
+		{0x7C, FlagSF | FlagOF, false},
+		{0x7D, FlagSF | FlagOF, true}, // JGE
+		{0x7D, 0, true},
+		{0x7D, FlagSF, false},
+		{0x7E, FlagZF, true}, // JLE
+		{0x7E, FlagOF, true},
+		{0x7E, 0, false},
+		{0x7F, 0, true}, // JG
+		{0x7F, FlagSF | FlagOF, true},
+		{0x7F, FlagZF, false},
+		{0x7F, FlagSF, false},
+	}
+
+	for _, tt := range tests {
+		c := newTestCPU(t, tt.opcode, 0x01, 0x40, hlt) // jcc +1; inc ax; hlt
+		c.FL |= tt.flags
+
+		err := c.Run(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taken := c.AX == 0; taken != tt.taken {
+			t.Errorf("%02X with %s: taken = %t, want %t", tt.opcode, flagList(tt.flags), taken, tt.taken)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "LOOP from 5",
+			code: []byte{
+				0xB9, 0x05, 0x00, // mov cx, 5
+				0x40,       // inc ax
+				0xE2, 0xFD, // loop -3
+				hlt,
+			},
+			regs: map[string]uint16{"AX": 0x0005, "CX": 0x0000},
+		},
+		{
+			name: "LOOPE stops when ZF clears",
+			code: []byte{
+				0xB9, 0x05, 0x00, // mov cx, 5
+				0x40,       // inc ax
+				0x3C, 0x02, // cmp al, 2
+				0xE1, 0xFB, // loope -5
+				hlt,
+			},
+			setup: func(c *CPU) { c.AX = 0x0001 },
+			regs:  map[string]uint16{"AX": 0x0003, "CX": 0x0003},
+		},
+		{
+			name: "LOOPNE stops when ZF sets",
+			code: []byte{
+				0xB9, 0x05, 0x00, // mov cx, 5
+				0x40,       // inc ax
+				0x3C, 0x02, // cmp al, 2
+				0xE0, 0xFB, // loopne -5
+				hlt,
+			},
+			regs: map[string]uint16{"AX": 0x0002, "CX": 0x0003},
+		},
+		{
+			name: "JCXZ with CX=0", code: []byte{0xE3, 0x01, 0x40, hlt},
+			regs: map[string]uint16{"AX": 0x0000, "CX": 0x0000},
+		},
+		{
+			name: "JCXZ with CX=1", code: []byte{0xE3, 0x01, 0x40, hlt},
+			setup: func(c *CPU) { c.CX = 0x0001 },
+			regs:  map[string]uint16{"AX": 0x0001, "CX": 0x0001},
+		},
+		{
+			name: "LOOP back across the top of the segment",
+			code: []byte{0xEA, 0xFE, 0xFF, 0x00, 0x00}, // jmp 0000:FFFE
+			setup: func(c *CPU) {
+				c.CX = 3
+				c.Memory[0xFFFE] = 0xE2 // loop -2, onto itself
+				c.Memory[0xFFFF] = 0xFE
+				c.Memory[0x0000] = hlt // IP wraps to 0000
+			},
+			regs: map[string]uint16{"CX": 0x0000, "IP": 0x0001},
+		},
+	})
+}
+
+func TestCallRet(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "near CALL and RET",
+			code: []byte{
+				0xE8, 0x02, 0x00, // call 0105
+				0x40,       // inc ax
+				hlt,        //
+				0x89, 0xE2, // mov dx, sp
+				0xC3, // ret
+			},
+			regs:  map[string]uint16{"AX": 0x0001, "DX": 0xFFFC, "SP": 0xFFFE},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0xFFFC, 2, 0x0103) },
+		},
+		{
+			name: "RET imm16 drops the arguments",
+			code: []byte{
+				0x50,             // push ax
+				0xE8, 0x01, 0x00, // call 0105
+				hlt,
+				0xC2, 0x02, 0x00, // ret 2
+			},
+			regs: map[string]uint16{"SP": 0xFFFE, "IP": 0x0105},
+		},
+		{
+			name: "CALL BX", code: []byte{0xFF, 0xD3, hlt, 0x40, 0xC3},
+			setup: func(c *CPU) { c.BX = 0x0103 },
+			regs:  map[string]uint16{"AX": 0x0001, "SP": 0xFFFE},
+		},
+		{
+			name: "far CALL and RETF", code: []byte{0x9A, 0x00, 0x00, 0x00, 0x20, hlt},
+			setup: func(c *CPU) { copy(c.Memory[0x20000:], []byte{0x89, 0xE2, 0x8C, 0xC8, 0xCB}) }, // mov dx, sp; mov ax, cs; retf
+			regs:  map[string]uint16{"DX": 0xFFFA, "AX": 0x2000, "CS": 0x0000, "SP": 0xFFFE},
+			check: func(t *testing.T, c *CPU) {
+				wantMem(t, c, 0xFFFA, 2, 0x0105)
+				wantMem(t, c, 0xFFFC, 2, 0x0000)
+			},
+		},
+		{
+			name: "CALL FAR [BX] and RETF 4",
+			code: []byte{0x50, 0x50, 0xFF, 0x1F, hlt}, // push ax; push ax; call far [bx]
+			setup: func(c *CPU) {
+				c.BX = 0x0200
+				copy(c.Memory[0x0200:], []byte{0x00, 0x00, 0x00, 0x30})
+				copy(c.Memory[0x30000:], []byte{0xCA, 0x04, 0x00}) // retf 4
+			},
+			regs: map[string]uint16{"SP": 0xFFFE, "CS": 0x0000, "IP": 0x0105},
+		},
+	})
+}
+
+func TestFlagInstructions(t *testing.T) {
+	others := FlagZF | FlagSF | FlagOF | FlagPF | FlagAF
+
+	runProgramTests(t, []programTest{
+		{name: "STC", code: []byte{0xF9, hlt}, set: FlagCF},
+		{name: "STC, CMC", code: []byte{0xF9, 0xF5, hlt}, clear: FlagCF},
+		{name: "STC, CMC, CLC", code: []byte{0xF9, 0xF5, 0xF8, hlt}, clear: FlagCF},
+		{name: "CMC", code: []byte{0xF5, hlt}, set: FlagCF},
+		{
+			name: "CLC, STC and CMC leave the other flags", code: []byte{0xF8, 0xF9, 0xF5, 0xF5, hlt},
+			setup: func(c *CPU) { c.FL |= others },
+			set:   FlagCF | others,
+		},
+		{name: "STD", code: []byte{0xFD, hlt}, set: FlagDF},
+		{name: "STD, CLD", code: []byte{0xFD, 0xFC, hlt}, clear: FlagDF},
+		{name: "STI", code: []byte{0xFB, hlt}, set: FlagIF},
+		{name: "STI, CLI", code: []byte{0xFB, 0xFA, hlt}, clear: FlagIF},
+	})
+}
+
+func TestNOP(t *testing.T) {
+	c := newTestCPU(t, 0x90, hlt)
+	c.AX, c.BX, c.CX, c.DX = 1, 2, 3, 4
+	before := c.DumpRegistersOnly()
+
+	_, err := c.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := c.DumpRegistersOnly()
+	if after.IP != before.IP+1 {
+		t.Errorf("IP = %04X, want %04X", after.IP, before.IP+1)
+	}
+	after.IP, after.Cycles, after.Instructions = before.IP, before.Cycles, before.Instructions
+	if after != before {
+		t.Errorf("NOP changed the registers: %+v, want %+v", after, before)
+	}
+}
+
+func TestHLTOnly(t *testing.T) {
+	c := newTestCPU(t, hlt)
+
+	err := c.Run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted || c.IP != 0x0101 {
+		t.Errorf("Halted = %t IP = %04X, want true 0101", c.Halted, c.IP)
+	}
+}
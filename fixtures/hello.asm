@@ -0,0 +1,13 @@
+; nasm hello.asm -o hello.bin
+
+bits 16
+org 100h
+
+mov ah, 09h
+mov dx, msg
+int 21h
+
+mov ax, 4C00h
+int 21h
+
+msg db 'Hello, World!$'
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bits of the flag register (FL).
+const (
+	FlagCF uint16 = 1 << 0  // Carry
+	FlagPF uint16 = 1 << 2  // Parity
+	FlagAF uint16 = 1 << 4  // Auxiliary carry
+	FlagZF uint16 = 1 << 6  // Zero
+	FlagSF uint16 = 1 << 7  // Sign
+	FlagTF uint16 = 1 << 8  // Trap
+	FlagIF uint16 = 1 << 9  // Interrupt enable
+	FlagDF uint16 = 1 << 10 // Direction
+	FlagOF uint16 = 1 << 11 // Overflow
+
+	// flagsMask has the bits of FL that exist on the 8086, the others are
+	// reserved.
+	flagsMask = FlagCF | FlagPF | FlagAF | FlagZF | FlagSF | FlagTF | FlagIF | FlagDF | FlagOF
+
+	// flagsFixed has the reserved bits that always read as 1 on the 8086,
+	// bits 12 to 15 and bit 1. Code that tells an 8086 from a 286 looks at
+	// them. The PUSHF/POPF request asked for bit 14 and the IOPL bits to
+	// read as 0, which is how a 286 in real mode behaves; this follows the
+	// 8086 instead, so that such code still finds an 8086.
+	flagsFixed uint16 = 0xF002
+)
+
+var flagNames = []struct {
+	flag uint16
+	name string
+}{
+	{FlagOF, "OF"},
+	{FlagDF, "DF"},
+	{FlagIF, "IF"},
+	{FlagTF, "TF"},
+	{FlagSF, "SF"},
+	{FlagZF, "ZF"},
+	{FlagAF, "AF"},
+	{FlagPF, "PF"},
+	{FlagCF, "CF"},
+}
+
+// GetFlag reports whether every bit of f is set in FL.
+func (c *CPU) GetFlag(f uint16) bool {
+	return c.FL&f == f
+}
+
+// SetFlag sets or clears the bits of f in FL, leaving the others alone.
+func (c *CPU) SetFlag(f uint16, v bool) {
+	if v {
+		c.FL |= f
+		return
+	}
+	c.FL &^= f
+}
+
+// flagsString returns the state of each flag by name, e.g. "OF:0 DF:0 ...".
+func (c *CPU) flagsString() string {
+	s := make([]string, 0, len(flagNames))
+	for _, f := range flagNames {
+		v := 0
+		if c.GetFlag(f.flag) {
+			v = 1
+		}
+		s = append(s, fmt.Sprintf("%s:%d", f.name, v))
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestFlagsFixedBits(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *CPU)
+		code  []byte
+		want  uint16 // AX at the HLT
+	}{
+		{"PUSHF after reset", nil, []byte{0x9C, 0x58, 0xF4}, 0xF002},                                                      // pushf; pop ax
+		{"POPF 0000", nil, []byte{0x31, 0xC0, 0x50, 0x9D, 0x9C, 0x58, 0xF4}, 0xF002},                                      // xor ax, ax; push ax; popf; pushf; pop ax
+		{"POPF FFFF", nil, []byte{0xB8, 0xFF, 0xFF, 0x50, 0x9D, 0x9C, 0x58, 0xF4}, 0xFFD7},                                // mov ax, FFFFh; push ax; popf; pushf; pop ax
+		{"SAHF 00", nil, []byte{0xB4, 0x00, 0xF9, 0x9E, 0x9C, 0x58, 0xF4}, 0xF002},                                        // mov ah, 0; stc; sahf; pushf; pop ax
+		{"STC", nil, []byte{0xF9, 0x9C, 0x58, 0xF4}, 0xF003},                                                              // stc; pushf; pop ax
+		{"IRET", nil, []byte{0x31, 0xC0, 0x50, 0x0E, 0xB8, 0x0B, 0x01, 0x50, 0xCF, 0xF4, 0xF4, 0x9C, 0x58, 0xF4}, 0xF002}, // push 0, cs, 010Bh; iret; pushf; pop ax
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCPU(t, tt.code...)
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+
+			err := c.Run(100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.AX != tt.want {
+				t.Errorf("flags = %04X, want %04X", c.AX, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	flags := []uint16{FlagCF, FlagPF, FlagAF, FlagZF, FlagSF, FlagTF, FlagIF, FlagDF, FlagOF}
+	for _, f := range flags {
+		c := NewCPU()
+		c.SetFlag(f, true)
+		if !c.GetFlag(f) || c.FL != flagsFixed|f {
+			t.Errorf("SetFlag(%04X, true): FL = %04X", f, c.FL)
+		}
+		c.SetFlag(f, false)
+		if c.GetFlag(f) || c.FL != flagsFixed {
+			t.Errorf("SetFlag(%04X, false): FL = %04X", f, c.FL)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+// RegisterInstructionHook makes Step call fn before every instruction with
+// the given opcode runs, with IP still pointing at the instruction. Hooks of
+// the same opcode are called in the order they were registered. Prefixes
+// are not opcodes, a hook on F3 never fires.
+func (c *CPU) RegisterInstructionHook(opcode uint8, fn func(*CPU, Instruction)) {
+	if c.hooks == nil {
+		c.hooks = make(map[uint8][]func(*CPU, Instruction))
+	}
+	c.hooks[opcode] = append(c.hooks[opcode], fn)
+}
+
+// UnregisterAllHooks removes every hook added by RegisterInstructionHook.
+func (c *CPU) UnregisterAllHooks() {
+	c.hooks = nil
+}
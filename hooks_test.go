@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstructionHooks(t *testing.T) {
+	c := newTestCPU(t,
+		0xB4, 0x02, // mov ah, 2
+		0xCD, 0x60, // int 60h
+		0xB4, 0x09, // mov ah, 9
+		0xCD, 0x60, // int 60h
+		hlt,
+	)
+	c.RegisterInterruptHandler(0x60, func(c *CPU) error { return nil })
+
+	var calls []string
+	c.RegisterInstructionHook(0xCD, func(c *CPU, inst Instruction) {
+		if inst.Imm != 0x60 || c.IP != 0x0102 && c.IP != 0x0106 {
+			t.Errorf("hook called for INT %02Xh at %04X", inst.Imm, c.IP)
+		}
+		calls = append(calls, "first AH="+string('0'+getAH(c)))
+	})
+	c.RegisterInstructionHook(0xCD, func(c *CPU, inst Instruction) {
+		calls = append(calls, "second AH="+string('0'+getAH(c)))
+	})
+
+	err := c.Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first AH=2", "second AH=2", "first AH=9", "second AH=9"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks called %q, want %q", calls, want)
+	}
+
+	c.UnregisterAllHooks()
+	calls = nil
+	c.ResetRegisters()
+	err = c.Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != nil {
+		t.Errorf("hooks called %q after UnregisterAllHooks", calls)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "errors"
+
+// The interrupt vector table fills the first 1KB of memory, one offset:segment
+// pair per vector, offset first.
+
+// RegisterInterruptHandler makes INT vector call fn instead of going through
+// the interrupt vector table. Nothing is pushed and CS:IP are left alone, fn
+// sees the CPU with IP already past the INT instruction. An error returned by
+// fn stops Run, except for ErrNotServiced. Handlers can also be set in
+// InterruptHandlers directly.
+func (c *CPU) RegisterInterruptHandler(vector uint8, fn func(*CPU) error) {
+	if c.InterruptHandlers == nil {
+		c.InterruptHandlers = make(map[uint8]func(*CPU) error)
+	}
+	c.InterruptHandlers[vector] = fn
+}
+
+// ErrNotServiced is returned by an interrupt handler that leaves the
+// interrupt to the guest, it then goes through the vector table as if there
+// was no handler.
+var ErrNotServiced = errors.New("interrupt not serviced")
+
+// interrupt raises the given vector. Without a registered handler, or when
+// the handler returns ErrNotServiced, it pushes FLAGS, CS and IP, clears IF
+// and TF and jumps through the vector table.
+func (c *CPU) interrupt(vector uint8) error {
+	if fn, ok := c.InterruptHandlers[vector]; ok {
+		err := fn(c)
+		if !errors.Is(err, ErrNotServiced) {
+			return err
+		}
+	}
+
+	pushWord(c, c.FL)
+	pushWord(c, c.CS)
+	pushWord(c, c.IP)
+	c.SetFlag(FlagIF, false)
+	c.SetFlag(FlagTF, false)
+
+	off := uint16(vector) * 4
+	c.IP = c.ReadMemWord(0, off)
+	c.CS = c.ReadMemWord(0, off+2)
+	return nil
+}
+
+// iret returns from an interrupt, popping IP, CS and FLAGS. The reserved
+// flag bits get their fixed values like POPF does.
+func (c *CPU) iret() {
+	c.IP = popWord(c)
+	c.CS = popWord(c)
+	c.FL = popWord(c)&flagsMask | flagsFixed
+}
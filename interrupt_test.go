@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// setVector points vector at seg:off in the interrupt vector table.
+func setVector(c *CPU, vector uint8, seg, off uint16) {
+	c.WriteMemWord(0, uint16(vector)*4, off)
+	c.WriteMemWord(0, uint16(vector)*4+2, seg)
+}
+
+func TestInterrupts(t *testing.T) {
+	// 0000:0200 saves SP and FLAGS, then returns
+	handler := []byte{
+		0x89, 0xE5, // mov bp, sp
+		0x9C, // pushf
+		0x5E, // pop si
+		0xCF, // iret
+	}
+
+	runProgramTests(t, []programTest{
+		{
+			name: "INT and IRET", code: []byte{0xFB, 0xF9, 0xCD, 0x10, hlt}, // sti; stc; int 10h
+			setup: func(c *CPU) {
+				setVector(c, 0x10, 0x0000, 0x0200)
+				copy(c.Memory[0x0200:], handler)
+			},
+			regs: map[string]uint16{"BP": 0xFFF8, "SP": 0xFFFE, "IP": 0x0105},
+			set:  FlagIF | FlagCF,
+			check: func(t *testing.T, c *CPU) {
+				wantMem(t, c, 0xFFF8, 2, 0x0104)                   // IP
+				wantMem(t, c, 0xFFFA, 2, 0x0000)                   // CS
+				wantMem(t, c, 0xFFFC, 2, flagsFixed|FlagIF|FlagCF) // FLAGS
+				if c.SI&FlagIF != 0 || c.SI&FlagCF == 0 {
+					t.Errorf("FLAGS in the handler %04X, want IF clear and CF kept", c.SI)
+				}
+			},
+		},
+		{
+			name: "INT 3", code: []byte{0xCC, hlt},
+			setup: func(c *CPU) {
+				setVector(c, 3, 0x0000, 0x0200)
+				copy(c.Memory[0x0200:], handler)
+			},
+			regs:  map[string]uint16{"BP": 0xFFF8, "IP": 0x0102},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0xFFF8, 2, 0x0101) },
+		},
+		{
+			name: "INTO with OF set", code: []byte{0xB0, 0x7F, 0x04, 0x01, 0xCE, hlt}, // mov al, 7Fh; add al, 1; into
+			setup: func(c *CPU) {
+				setVector(c, 4, 0x0000, 0x0200)
+				copy(c.Memory[0x0200:], handler)
+			},
+			regs: map[string]uint16{"BP": 0xFFF8},
+		},
+		{
+			name: "INTO with OF clear", code: []byte{0xCE, hlt},
+			setup: func(c *CPU) {
+				setVector(c, 4, 0x0000, 0x0200)
+				copy(c.Memory[0x0200:], handler)
+			},
+			regs: map[string]uint16{"BP": 0x0000, "SP": 0xFFFE},
+		},
+		{
+			name: "handler in another segment", code: []byte{0xCD, 0x80, hlt},
+			setup: func(c *CPU) {
+				setVector(c, 0x80, 0x3000, 0x0010)
+				copy(c.Memory[0x30010:], []byte{0x8C, 0xC8, 0xCF}) // mov ax, cs; iret
+			},
+			regs: map[string]uint16{"AX": 0x3000, "CS": 0x0000, "IP": 0x0103},
+		},
+	})
+}
+
+func TestInterruptHandlers(t *testing.T) {
+	t.Run("Go handler", func(t *testing.T) {
+		c := newTestCPU(t, 0xB2, 0x41, 0xCD, 0x21, hlt) // mov dl, 'A'; int 21h
+		var got uint8
+		c.RegisterInterruptHandler(0x21, func(c *CPU) error {
+			got = getDL(c)
+			setAL(c, got+1)
+			return nil
+		})
+
+		err := c.Run(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 'A' || getAL(c) != 'B' || c.SP != 0xFFFE {
+			t.Errorf("handler saw DL=%02X, AL=%02X SP=%04X, want 41 42 FFFE", got, getAL(c), c.SP)
+		}
+	})
+
+	t.Run("ErrNotServiced", func(t *testing.T) {
+		c := newTestCPU(t, 0xCD, 0x21, hlt)
+		setVector(c, 0x21, 0x0000, 0x0200)
+		copy(c.Memory[0x0200:], []byte{0xB8, 0x34, 0x12, 0xCF}) // mov ax, 1234h; iret
+		called := false
+		c.RegisterInterruptHandler(0x21, func(c *CPU) error {
+			called = true
+			return ErrNotServiced
+		})
+
+		err := c.Run(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !called || c.AX != 0x1234 {
+			t.Errorf("called = %t AX = %04X, want true 1234", called, c.AX)
+		}
+	})
+
+	t.Run("error stops Run", func(t *testing.T) {
+		c := newTestCPU(t, 0xCD, 0x21, hlt)
+		failed := errors.New("failed")
+		c.RegisterInterruptHandler(0x21, func(c *CPU) error { return failed })
+
+		err := c.Run(100)
+		if !errors.Is(err, failed) {
+			t.Errorf("Run = %v, want the handler's error", err)
+		}
+		if c.Halted {
+			t.Error("Run went on to the HLT")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// pspSize is the size of the program segment prefix DOS puts in front of a
+// program.
+const pspSize = 0x100
+
+// exePSPSegment is where LoadEXEFile puts the PSP, the program follows it.
+// Everything below, the interrupt vector table included, is left alone.
+const exePSPSegment = 0x0100
+
+// LoadCOMFile loads the COM program filename the way DOS does: at offset
+// 0100 of segment, after a program segment prefix. CS, DS, ES and SS all
+// point at segment, IP is 0100 and SP is FFFE.
+func (c *CPU) LoadCOMFile(filename string, segment uint16) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0x10000-pspSize-2 { // leave room for the initial stack word
+		return fmt.Errorf("%s: %d bytes is too big for a COM program", filename, len(data))
+	}
+
+	c.CS, c.DS, c.ES, c.SS = segment, segment, segment, segment
+	err = c.LoadProgramFromBytes(data)
+	if err != nil {
+		return err
+	}
+	c.SP = 0xFFFE
+	c.WriteMemWord(segment, 0xFFFE, 0) // a RET from the program goes to the INT 20h
+
+	c.writePSP(segment, segment+0x1000)
+	return nil
+}
+
+// writePSP writes a minimal program segment prefix at segment: INT 20h at
+// offset 0 so a jump there ends the program, the first segment past the
+// memory given to the program, top, at offset 2 and an empty command tail at
+// offset 80h.
+func (c *CPU) writePSP(segment, top uint16) {
+	for off := uint16(0); off < pspSize; off++ {
+		c.WriteMemByte(segment, off, 0)
+	}
+	c.WriteMemByte(segment, 0x00, 0xCD) // INT 20h
+	c.WriteMemByte(segment, 0x01, 0x20)
+	c.WriteMemWord(segment, 0x02, top)
+	c.WriteMemByte(segment, 0x80, 0)    // command tail length
+	c.WriteMemByte(segment, 0x81, 0x0D) // and its terminator
+}
+
+// ErrNotMZExecutable is returned by LoadEXEFile for a file that does not
+// start with the MZ signature.
+var ErrNotMZExecutable = errors.New("not an MZ executable")
+
+// mzHeader is the fixed part of an EXE header, all of it little endian words.
+type mzHeader struct {
+	Magic         uint16 // "MZ"
+	LastPageBytes uint16 // bytes used in the last 512 byte page, 0 if full
+	Pages         uint16 // 512 byte pages in the file, header included
+	Relocations   uint16 // entries in the relocation table
+	HeaderParas   uint16 // size of the header in 16 byte paragraphs
+	MinAlloc      uint16
+	MaxAlloc      uint16
+	SS, SP        uint16 // SS relative to the load segment
+	Checksum      uint16
+	IP, CS        uint16 // CS relative to the load segment
+	RelocTableOff uint16 // file offset of the relocation table
+	OverlayNumber uint16
+}
+
+// LoadEXEFile loads the MZ executable filename the way DOS does. The PSP goes
+// at segment 0100 and the program right after it. Every relocation gets the
+// load segment added, and CS:IP and SS:SP come from the header, relative to
+// the load segment. DS and ES point at the PSP.
+func (c *CPU) LoadEXEFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 || data[0] != 'M' || data[1] != 'Z' {
+		return ErrNotMZExecutable
+	}
+
+	var h mzHeader
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &h)
+	if err != nil {
+		return fmt.Errorf("%s: EXE header: %w", filename, err)
+	}
+
+	// the file size comes from the header, anything past it is an overlay
+	size := int(h.Pages) * 512
+	if h.LastPageBytes != 0 {
+		size -= 512 - int(h.LastPageBytes)
+	}
+	start := int(h.HeaderParas) * 16
+	if size > len(data) || start > size {
+		return fmt.Errorf("%s: header says %d bytes with a %d byte header, the file has %d", filename, size, start, len(data))
+	}
+	image := data[start:size]
+
+	loadSegment := uint16(exePSPSegment + pspSize/16)
+	loadAddr := int(physicalAddress(loadSegment, 0))
+	if loadAddr+len(image) > len(c.Memory) {
+		return fmt.Errorf("%s: program of %d bytes does not fit in memory", filename, len(image))
+	}
+	relocs := int(h.RelocTableOff)
+	if relocs+int(h.Relocations)*4 > len(data) {
+		return fmt.Errorf("%s: relocation table past the end of the file", filename)
+	}
+
+	// the file checks out, nothing is written to memory before this point
+	copy(c.Memory[loadAddr:], image)
+	for i := 0; i < int(h.Relocations); i++ {
+		entry := data[relocs+i*4:]
+		off := binary.LittleEndian.Uint16(entry)
+		seg := binary.LittleEndian.Uint16(entry[2:]) + loadSegment
+		c.WriteMemWord(seg, off, c.ReadMemWord(seg, off)+loadSegment)
+	}
+
+	c.writePSP(exePSPSegment, 0xA000) // all conventional memory
+	c.CS, c.IP = loadSegment+h.CS, h.IP
+	c.SS, c.SP = loadSegment+h.SS, h.SP
+	c.DS, c.ES = exePSPSegment, exePSPSegment
+	c.programStart = loadAddr
+	c.programSize = len(image)
+	return nil
+}
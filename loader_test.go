@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCOMFile(t *testing.T) {
+	c := NewCPUWithDOS()
+	var out bytes.Buffer
+	c.SetOutput(&out)
+
+	err := c.LoadCOMFile(writeCOM(t, 0xB8, 0x34, 0x12, 0xC3), 0x2000) // mov ax, 1234h; ret
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs := c.DumpRegistersOnly()
+	if regs.CS != 0x2000 || regs.DS != 0x2000 || regs.ES != 0x2000 || regs.SS != 0x2000 ||
+		regs.IP != 0x0100 || regs.SP != 0xFFFE {
+		t.Errorf("registers after LoadCOMFile %+v", regs)
+	}
+	wantMem(t, c, 0x2FFFE, 2, 0x0000) // return address into the PSP
+	wantMem(t, c, 0x20000, 2, 0x20CD) // INT 20h
+	wantMem(t, c, 0x20002, 2, 0x3000) // top of memory
+	wantMem(t, c, 0x20080, 2, 0x0D00) // empty command tail
+	wantMem(t, c, 0x20100, 1, 0xB8)
+
+	err = c.Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted || c.AX != 0x1234 {
+		t.Errorf("Halted = %t AX = %04X, want true 1234", c.Halted, c.AX)
+	}
+}
+
+func TestLoadCOMFileErrors(t *testing.T) {
+	c := NewCPU()
+
+	err := c.LoadCOMFile(filepath.Join(t.TempDir(), "missing.com"), 0x1000)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadCOMFile of a missing file = %v, want ErrNotExist", err)
+	}
+	err = c.LoadCOMFile(writeCOM(t, make([]byte, 0xFF00)...), 0x1000)
+	if err == nil {
+		t.Error("LoadCOMFile of a too big program did not fail")
+	}
+}
+
+// buildEXE returns an MZ executable with image after a header built from h
+// and the relocation entries, each a segment:offset pair relative to the
+// load segment.
+func buildEXE(h mzHeader, relocs [][2]uint16, image []byte) []byte {
+	const fixedSize = 28 // the words of mzHeader
+	headerSize := fixedSize + 4*len(relocs)
+	headerSize = (headerSize + 15) &^ 15
+
+	h.Magic = 'M' | 'Z'<<8
+	h.Relocations = uint16(len(relocs))
+	h.RelocTableOff = fixedSize
+	h.HeaderParas = uint16(headerSize / 16)
+	total := headerSize + len(image)
+	h.Pages = uint16((total + 511) / 512)
+	h.LastPageBytes = uint16(total % 512)
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, h)
+	for _, r := range relocs {
+		binary.Write(&b, binary.LittleEndian, []uint16{r[1], r[0]})
+	}
+	b.Write(make([]byte, headerSize-b.Len()))
+	b.Write(image)
+	return b.Bytes()
+}
+
+// writeEXE writes data to an EXE file in a temporary directory and returns
+// its name.
+func writeEXE(t *testing.T, data []byte) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.exe")
+	err := os.WriteFile(name, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadEXEFile(t *testing.T) {
+	const loadSegment = exePSPSegment + pspSize/16
+
+	image := []byte{
+		// code segment, relative 0000
+		0xB8, 0x01, 0x00, // mov ax, seg data
+		0x8E, 0xD8, // mov ds, ax
+		0xA1, 0x00, 0x00, // mov ax, [0]
+		hlt,
+		0, 0, 0, 0, 0, 0, 0,
+		// data segment, relative 0001
+		0x34, 0x12,
+	}
+	name := writeEXE(t, buildEXE(mzHeader{SS: 0x0010, SP: 0x0200, IP: 0x0000, CS: 0x0000},
+		[][2]uint16{{0x0000, 0x0001}}, image))
+
+	c := NewCPU()
+	err := c.LoadEXEFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs := c.DumpRegistersOnly()
+	want := CPURegisters{
+		CS: loadSegment, IP: 0x0000,
+		SS: loadSegment + 0x0010, SP: 0x0200,
+		DS: exePSPSegment, ES: exePSPSegment,
+		FL: flagsFixed,
+	}
+	if regs != want {
+		t.Errorf("registers after LoadEXEFile %+v, want %+v", regs, want)
+	}
+	wantMem(t, c, physicalAddress(loadSegment, 1), 2, loadSegment+0x0001)
+	wantMem(t, c, physicalAddress(exePSPSegment, 0), 2, 0x20CD)
+
+	err = c.Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DS != loadSegment+1 || c.AX != 0x1234 {
+		t.Errorf("DS = %04X AX = %04X, want %04X 1234", c.DS, c.AX, loadSegment+1)
+	}
+}
+
+func TestLoadEXEFileErrors(t *testing.T) {
+	valid := buildEXE(mzHeader{}, [][2]uint16{{0, 0}}, []byte{hlt})
+	pastEnd := append([]byte{}, valid...)
+	pastEnd[4] = 2 // two pages for a file of 33 bytes
+	badRelocs := append([]byte{}, valid...)
+	badRelocs[0x18] = 0x20 // the table starts at the HLT
+
+	tests := []struct {
+		name  string
+		data  []byte
+		notMZ bool
+	}{
+		{"COM program", []byte{0xB8, 0x34, 0x12, hlt}, true},
+		{"empty", nil, true},
+		{"truncated header", valid[:10], false},
+		{"image past the end", pastEnd, false},
+		{"relocations past the end", badRelocs, false},
+	}
+
+	c := NewCPU()
+	err := c.LoadEXEFile(writeEXE(t, valid))
+	if err != nil {
+		t.Fatalf("LoadEXEFile of the unchanged file = %v", err)
+	}
+
+	for _, tt := range tests {
+		c := NewCPU()
+		err := c.LoadEXEFile(writeEXE(t, tt.data))
+		if err == nil {
+			t.Errorf("%s: LoadEXEFile did not fail", tt.name)
+			continue
+		}
+		if errors.Is(err, ErrNotMZExecutable) != tt.notMZ {
+			t.Errorf("%s: LoadEXEFile = %v, want ErrNotMZExecutable %t", tt.name, err, tt.notMZ)
+		}
+		if c.Memory != [len(c.Memory)]byte{} || c.DumpRegistersOnly() != NewCPU().DumpRegistersOnly() {
+			t.Errorf("%s: a failed LoadEXEFile changed the CPU", tt.name)
+		}
+	}
+}
@@ -1,11 +1,23 @@
 package main
 
+import (
+	"errors"
+	"log"
+)
+
 func main() {
-	cpu := NewCPU()
-	//cpu.Run()
+	cpu := NewCPUWithDOS()
+	cpu.Verbose = true
+
+	err := cpu.LoadProgram("fixtures/mov_cx_bx.bin")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	cpu.LoadProgram("fixtures/mov_cx_bx.bin")
+	err = cpu.Run(0)
+	if err != nil && !errors.Is(err, ErrEndOfProgram) {
+		log.Fatal(err)
+	}
 
-	cpu.DecodeInatruction()
 	cpu.PrintRegisters()
 }
@@ -0,0 +1,124 @@
+package main
+
+// physicalAddress returns the 20-bit linear address of seg:off. Like the
+// real chip, addresses past the end of the 1MB space wrap around to zero.
+func physicalAddress(seg, off uint16) uint32 {
+	return (uint32(seg)<<4 + uint32(off)) & 0xFFFFF
+}
+
+// All memory accesses go through the accessors below, which take a
+// segment:offset pair. They are named ReadMemByte/WriteMemByte rather than
+// ReadByte/WriteByte so they don't clash with the io.ByteReader and
+// io.ByteWriter signatures.
+
+// ReadMemByte returns the byte at seg:off.
+func (c *CPU) ReadMemByte(seg, off uint16) uint8 {
+	v := c.Memory[physicalAddress(seg, off)]
+	c.watch(c.readWatchpoints, seg, off, 1, false, uint16(v))
+	return v
+}
+
+// WriteMemByte stores v at seg:off.
+func (c *CPU) WriteMemByte(seg, off uint16, v uint8) {
+	c.Memory[physicalAddress(seg, off)] = v
+	c.watch(c.writeWatchpoints, seg, off, 1, true, uint16(v))
+}
+
+// ReadMemWord returns the little-endian word at seg:off. Like on the 8086,
+// the high byte of a word at offset 0xFFFF comes from offset 0 of the same
+// segment.
+func (c *CPU) ReadMemWord(seg, off uint16) uint16 {
+	v := uint16(c.Memory[physicalAddress(seg, off)]) |
+		uint16(c.Memory[physicalAddress(seg, off+1)])<<8
+	c.watch(c.readWatchpoints, seg, off, 2, false, v)
+	return v
+}
+
+// WriteMemWord stores v at seg:off, low byte first.
+func (c *CPU) WriteMemWord(seg, off uint16, v uint16) {
+	c.Memory[physicalAddress(seg, off)] = uint8(v)
+	c.Memory[physicalAddress(seg, off+1)] = uint8(v >> 8)
+	c.watch(c.writeWatchpoints, seg, off, 2, true, v)
+}
+
+// readMem reads a byte or a word, as given by width, at seg:off.
+func (c *CPU) readMem(seg, off uint16, width uint8) uint16 {
+	if width == 1 {
+		return uint16(c.ReadMemByte(seg, off))
+	}
+	return c.ReadMemWord(seg, off)
+}
+
+// writeMem writes a byte or a word, as given by width, at seg:off.
+func (c *CPU) writeMem(seg, off uint16, width uint8, v uint16) {
+	if width == 1 {
+		c.WriteMemByte(seg, off, uint8(v))
+		return
+	}
+	c.WriteMemWord(seg, off, v)
+}
+
+// dataSegment returns DS, or the segment named by a segment override prefix
+// when there is one.
+func (c *CPU) dataSegment() uint16 {
+	if c.segmentOverride != nil {
+		return *c.segmentOverride
+	}
+	return c.DS
+}
+
+// dispLen returns how many displacement bytes follow a mod reg r/m byte.
+func dispLen(mod, rm uint8) uint8 {
+	switch {
+	case mod == 0b01:
+		return 1
+	case mod == 0b10, mod == 0b00 && rm == 0b110:
+		return 2
+	}
+	return 0
+}
+
+// EffectiveAddress returns the segment and offset of the memory operand
+// described by mod and r/m, every instruction with a memory operand gets its
+// address here and LEA loads the offset alone. disp is the displacement the
+// decoder read after the mod reg r/m byte, sign extended, and zero when the
+// encoding has none. Addresses based on BP use SS by default, everything
+// else uses DS, unless a segment override prefix is in effect. Register mode
+// (mod 11) has no memory address and returns zeros.
+func EffectiveAddress(c *CPU, mod, rm uint8, disp uint16) (seg uint16, off uint16) {
+	if mod == 0b11 {
+		return 0, 0
+	}
+
+	segment := c.DS
+	var offset uint16
+	switch rm {
+	case 0b000:
+		offset = c.BX + c.SI
+	case 0b001:
+		offset = c.BX + c.DI
+	case 0b010:
+		offset = c.BP + c.SI
+		segment = c.SS
+	case 0b011:
+		offset = c.BP + c.DI
+		segment = c.SS
+	case 0b100:
+		offset = c.SI
+	case 0b101:
+		offset = c.DI
+	case 0b110:
+		if mod != 0b00 { // mod 00 is a direct address
+			offset = c.BP
+			segment = c.SS
+		}
+	default:
+		offset = c.BX
+	}
+
+	if c.segmentOverride != nil {
+		segment = *c.segmentOverride
+	}
+
+	return segment, offset + disp
+}
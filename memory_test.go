@@ -0,0 +1,153 @@
+package main
+
+import "testing"
+
+func TestPhysicalAddress(t *testing.T) {
+	tests := []struct {
+		seg, off uint16
+		want     uint32
+	}{
+		{0x0000, 0x0000, 0x00000},
+		{0x1234, 0x5678, 0x179B8},
+		{0xA000, 0x0000, 0xA0000}, // the same byte
+		{0x9FFF, 0x0010, 0xA0000}, // through three
+		{0xA000 - 0x0FFF, 0xFFF0, 0xA0000},
+		{0xFFFF, 0x000F, 0xFFFFF},
+		{0xFFFF, 0x0010, 0x00000}, // wraps around the 1MB
+		{0xFFFF, 0xFFFF, 0x0FFEF},
+	}
+
+	for _, tt := range tests {
+		if got := physicalAddress(tt.seg, tt.off); got != tt.want {
+			t.Errorf("physicalAddress(%04X, %04X) = %05X, want %05X", tt.seg, tt.off, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryAccessors(t *testing.T) {
+	c := NewCPU()
+
+	c.WriteMemWord(0x0100, 0x0010, 0x1234)
+	if lo, hi := c.ReadMemByte(0x0100, 0x0010), c.ReadMemByte(0x0100, 0x0011); lo != 0x34 || hi != 0x12 {
+		t.Errorf("bytes of 1234 = %02X %02X, want 34 12", lo, hi)
+	}
+	if got := c.ReadMemWord(0x0101, 0x0000); got != 0x1234 {
+		t.Errorf("ReadMemWord through another segment = %04X, want 1234", got)
+	}
+
+	c.WriteMemByte(0x0200, 0x0000, 0xAB)
+	if c.Memory[0x2000] != 0xAB {
+		t.Errorf("WriteMemByte wrote to the wrong address")
+	}
+
+	// a word at offset FFFF wraps within the segment
+	c.WriteMemWord(0x1000, 0xFFFF, 0xCAFE)
+	if c.Memory[0x1FFFF] != 0xFE || c.Memory[0x10000] != 0xCA {
+		t.Errorf("word at 1000:FFFF = %02X %02X, want FE at 1FFFF and CA at 10000", c.Memory[0x1FFFF], c.Memory[0x10000])
+	}
+	if got := c.ReadMemWord(0x1000, 0xFFFF); got != 0xCAFE {
+		t.Errorf("ReadMemWord(1000, FFFF) = %04X, want CAFE", got)
+	}
+
+	// and the 1MB space wraps around to zero
+	c.WriteMemWord(0xFFFF, 0x000F, 0xBEEF)
+	if c.Memory[0xFFFFF] != 0xEF || c.Memory[0x00000] != 0xBE {
+		t.Errorf("word at FFFF:000F = %02X %02X, want EF at FFFFF and BE at 00000", c.Memory[0xFFFFF], c.Memory[0x00000])
+	}
+}
+
+func TestEffectiveAddress(t *testing.T) {
+	setup := func(c *CPU) {
+		c.BX, c.SI, c.DI, c.BP = 0x1000, 0x0200, 0x0030, 0x2000
+		c.DS, c.SS = 0x0100, 0x0300
+	}
+
+	// the offset of each r/m without a displacement
+	base := [8]struct{ seg, off uint16 }{
+		{0x0100, 0x1200}, // BX+SI
+		{0x0100, 0x1030}, // BX+DI
+		{0x0300, 0x2200}, // BP+SI
+		{0x0300, 0x2030}, // BP+DI
+		{0x0100, 0x0200}, // SI
+		{0x0100, 0x0030}, // DI
+		{0x0300, 0x2000}, // BP
+		{0x0100, 0x1000}, // BX
+	}
+
+	for mod := uint8(0b00); mod <= 0b10; mod++ {
+		for rm := uint8(0); rm < 8; rm++ {
+			// the displacement is -2, FE as a byte and FE FF as a word
+			want := base[rm]
+			disp := []byte{0xFE, 0xFF}[:dispLen(mod, rm)]
+			switch {
+			case mod == 0b00 && rm == 0b110:
+				want = struct{ seg, off uint16 }{0x0100, 0xFFFE} // direct address
+			case mod != 0b00:
+				want.off -= 2
+			}
+
+			var d uint16
+			if len(disp) > 0 {
+				d = 0xFFFE
+			}
+			c := NewCPU()
+			setup(c)
+			seg, off := EffectiveAddress(c, mod, rm, d)
+			if seg != want.seg || off != want.off {
+				t.Errorf("mod %02b r/m %03b: %04X:%04X, want %04X:%04X", mod, rm, seg, off, want.seg, want.off)
+			}
+
+			// MOV AX, r/m must read from the same address
+			code := append([]byte{0x8B, mod<<6 | rm}, disp...)
+			c = newTestCPU(t, append(code, hlt)...)
+			setup(c)
+			c.WriteMemWord(want.seg, want.off, 0xBEEF)
+			err := c.Run(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.AX != 0xBEEF || c.IP != 0x0101+uint16(len(code)) {
+				t.Errorf("mod %02b r/m %03b: MOV read %04X with IP %04X, want BEEF from %04X:%04X", mod, rm, c.AX, c.IP, want.seg, want.off)
+			}
+		}
+	}
+
+	c := NewCPU()
+	setup(c)
+	if seg, off := EffectiveAddress(c, 0b11, 0, 0); seg != 0 || off != 0 {
+		t.Errorf("mod 11: %04X:%04X, want zeros", seg, off)
+	}
+}
+
+func TestSegmentOverride(t *testing.T) {
+	c := newTestCPU(t,
+		0x26, 0x8B, 0x07, // mov ax, es:[bx]
+		0x8B, 0x0F, //       mov cx, [bx]
+		0x2E, 0x8A, 0x17, // mov dl, cs:[bx]
+		0x36, 0x88, 0x37, // mov ss:[bx], dh
+		0xF4, //             hlt
+	)
+	c.BX = 0x0010
+	c.DS, c.ES, c.SS = 0x1000, 0x2000, 0x3000
+	c.WriteMemWord(0x1000, 0x0010, 0x1111)
+	c.WriteMemWord(0x2000, 0x0010, 0x2222)
+	c.Memory[0x0010] = 0x33 // CS is 0000
+	c.DX = 0x4400
+
+	err := c.Run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AX != 0x2222 {
+		t.Errorf("ES:[BX] = %04X, want 2222", c.AX)
+	}
+	if c.CX != 0x1111 {
+		t.Errorf("[BX] after an override = %04X, want 1111 from DS", c.CX)
+	}
+	if getDL(c) != 0x33 {
+		t.Errorf("CS:[BX] = %02X, want 33", getDL(c))
+	}
+	if got := c.Memory[0x30010]; got != 0x44 {
+		t.Errorf("SS:[BX] = %02X, want 44", got)
+	}
+}
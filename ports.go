@@ -0,0 +1,46 @@
+package main
+
+import "log"
+
+// PortIn reads from an I/O port, w is the W bit: 0 for a byte and 1 for a
+// word.
+type PortIn func(port uint16, w uint8) uint16
+
+// PortOut writes value to an I/O port, w is the W bit like in PortIn.
+type PortOut func(port uint16, w uint8, value uint16)
+
+// NewCPUWithPorts returns a CPU that runs IN and OUT through in and out.
+func NewCPUWithPorts(in PortIn, out PortOut) *CPU {
+	c := NewCPU()
+	c.PortIn, c.PortOut = in, out
+	return c
+}
+
+// unconnectedPortIn is the default PortIn. Nothing is connected, so like on
+// an open bus every bit reads as one.
+func unconnectedPortIn(port uint16, w uint8) uint16 {
+	log.Printf("IN from unconnected port %04Xh", port)
+	if w == 0 {
+		return 0xFF
+	}
+	return 0xFFFF
+}
+
+// unconnectedPortOut is the default PortOut, the value is dropped.
+func unconnectedPortOut(port uint16, w uint8, value uint16) {
+	log.Printf("OUT %04Xh to unconnected port %04Xh", value, port)
+}
+
+// execIO runs IN and OUT, the port is either an immediate byte or DX.
+func (c *CPU) execIO(inst Instruction) {
+	port := inst.Imm
+	if inst.Opcode >= 0xEC {
+		port = c.DX
+	}
+
+	if inst.Mnemonic == "IN" {
+		c.writeOperand(inst, accumulator, c.PortIn(port, inst.W))
+		return
+	}
+	c.PortOut(port, inst.W, c.readOperand(inst, accumulator))
+}
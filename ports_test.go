@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestPorts(t *testing.T) {
+	type access struct {
+		port  uint16
+		w     uint8
+		value uint16
+	}
+	var ins, outs []access
+
+	c := NewCPUWithPorts(
+		func(port uint16, w uint8) uint16 {
+			ins = append(ins, access{port, w, 0})
+			if w == 0 {
+				return 0x5A
+			}
+			return 0xBEEF
+		},
+		func(port uint16, w uint8, value uint16) {
+			outs = append(outs, access{port, w, value})
+		},
+	)
+	c.ResetRegisters()
+	err := c.LoadProgramFromBytes([]byte{
+		0xE4, 0x60, // in al, 60h
+		0xE6, 0x61, // out 61h, al
+		0xBA, 0xF8, 0x03, // mov dx, 3F8h
+		0xED, // in ax, dx
+		0xEF, // out dx, ax
+		hlt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AX = 0x1200
+
+	err = c.Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantIns := []access{{0x60, 0, 0}, {0x3F8, 1, 0}}
+	wantOuts := []access{{0x61, 0, 0x005A}, {0x3F8, 1, 0xBEEF}}
+	if len(ins) != len(wantIns) || len(outs) != len(wantOuts) {
+		t.Fatalf("got IN %v OUT %v, want IN %v OUT %v", ins, outs, wantIns, wantOuts)
+	}
+	for i := range wantIns {
+		if ins[i] != wantIns[i] {
+			t.Errorf("IN %d = %+v, want %+v", i, ins[i], wantIns[i])
+		}
+	}
+	for i := range wantOuts {
+		if outs[i] != wantOuts[i] {
+			t.Errorf("OUT %d = %+v, want %+v", i, outs[i], wantOuts[i])
+		}
+	}
+	if c.AX != 0xBEEF {
+		t.Errorf("AX = %04X, want BEEF", c.AX)
+	}
+}
+
+func TestUnconnectedPorts(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{name: "IN AL", code: []byte{0xE4, 0x60, hlt}, regs: map[string]uint16{"AX": 0x00FF}},
+		{name: "IN AX", code: []byte{0xE5, 0x60, hlt}, regs: map[string]uint16{"AX": 0xFFFF}},
+		{name: "OUT is dropped", code: []byte{0xE6, 0x60, hlt}, setup: func(c *CPU) { c.AX = 0x1234 }, regs: map[string]uint16{"AX": 0x1234}},
+	})
+}
@@ -0,0 +1,15 @@
+package main
+
+// pushWord pushes v on the stack at SS:SP, the stack grows down. Like the real
+// chip there is no overflow check, SP just wraps around within SS.
+func pushWord(c *CPU, v uint16) {
+	c.SP -= 2
+	c.WriteMemWord(c.SS, c.SP, v)
+}
+
+// popWord pops the word at SS:SP.
+func popWord(c *CPU) uint16 {
+	v := c.ReadMemWord(c.SS, c.SP)
+	c.SP += 2
+	return v
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CPURegisters is a copy of the registers and flags of a CPU, together with
+// Halted, ExitCode and the counters, so going back to it also rewinds Cycles
+// and Instructions.
+type CPURegisters struct {
+	AX, BX, CX, DX uint16
+	SI, DI, BP, SP uint16
+	CS, DS, ES, SS uint16
+	IP, PC         uint16
+	FL, Flag       uint16
+	Halted         bool
+	ExitCode       uint8
+	Cycles         uint64
+	Instructions   uint64
+}
+
+// CPUState is a full copy of the state of a CPU, all of memory included.
+type CPUState struct {
+	Registers    CPURegisters
+	ProgramStart int
+	ProgramSize  int
+	Memory       [1048576]byte
+}
+
+// DumpRegistersOnly returns a copy of the registers, see CPURegisters. It is
+// cheap enough to take before every instruction.
+func (c *CPU) DumpRegistersOnly() CPURegisters {
+	return CPURegisters{
+		AX: c.AX, BX: c.BX, CX: c.CX, DX: c.DX,
+		SI: c.SI, DI: c.DI, BP: c.BP, SP: c.SP,
+		CS: c.CS, DS: c.DS, ES: c.ES, SS: c.SS,
+		IP: c.IP, PC: c.PC,
+		FL: c.FL, Flag: c.Flag,
+		Halted:       c.Halted,
+		ExitCode:     c.ExitCode,
+		Cycles:       c.Cycles,
+		Instructions: c.Instructions,
+	}
+}
+
+// RestoreRegisters puts back the registers taken by DumpRegistersOnly,
+// memory is left alone.
+func (c *CPU) RestoreRegisters(r CPURegisters) {
+	c.AX, c.BX, c.CX, c.DX = r.AX, r.BX, r.CX, r.DX
+	c.SI, c.DI, c.BP, c.SP = r.SI, r.DI, r.BP, r.SP
+	c.CS, c.DS, c.ES, c.SS = r.CS, r.DS, r.ES, r.SS
+	c.IP, c.PC = r.IP, r.PC
+	c.FL, c.Flag = r.FL&flagsMask|flagsFixed, r.Flag
+	c.Halted, c.ExitCode = r.Halted, r.ExitCode
+	c.Cycles, c.Instructions = r.Cycles, r.Instructions
+	c.segmentOverride = nil
+	c.lastBreakpoint = nil
+}
+
+// DumpState returns a copy of the registers and of all of memory. That is
+// over a megabyte, use DumpRegistersOnly where memory is not needed.
+func (c *CPU) DumpState() CPUState {
+	return CPUState{
+		Registers:    c.DumpRegistersOnly(),
+		ProgramStart: c.programStart,
+		ProgramSize:  c.programSize,
+		Memory:       c.Memory,
+	}
+}
+
+// RestoreState puts the CPU back in the state DumpState took, keeping its
+// configuration like Reset does.
+func (c *CPU) RestoreState(s CPUState) {
+	c.RestoreRegisters(s.Registers)
+	c.programStart = s.ProgramStart
+	c.programSize = s.ProgramSize
+	c.Memory = s.Memory
+}
+
+// cpuState is the JSON form of a CPU. Memory holds only the parts of memory
+// that are not zero, encoding/json writes their bytes as base64.
+type cpuState struct {
+	CPURegisters
+
+	ProgramStart int
+	ProgramSize  int
+	Memory       []memoryBlock
+}
+
+// memoryBlock is a run of memory starting at the physical address Addr.
+type memoryBlock struct {
+	Addr int
+	Data []byte
+}
+
+// blockGap is how many zero bytes it takes to end a memoryBlock, shorter
+// runs of zeros are kept inside the block.
+const blockGap = 16
+
+// usedMemory returns the parts of memory that are not zero, which is all a
+// CPU fresh from NewCPU lacks to be a copy of c.
+func (c *CPU) usedMemory() []memoryBlock {
+	var blocks []memoryBlock
+	start, end := -1, 0 // the block being built, end is past its last non-zero byte
+	for i, b := range c.Memory {
+		if b == 0 {
+			continue
+		}
+		if start >= 0 && i-end >= blockGap {
+			blocks = append(blocks, memoryBlock{Addr: start, Data: c.Memory[start:end]})
+			start = -1
+		}
+		if start < 0 {
+			start = i
+		}
+		end = i + 1
+	}
+	if start >= 0 {
+		blocks = append(blocks, memoryBlock{Addr: start, Data: c.Memory[start:end]})
+	}
+	return blocks
+}
+
+// MarshalJSON saves the registers, the flags and every part of memory that
+// is not zero: the program, its data and its stack, but not the whole
+// megabyte. A checkpoint can be taken between any two instructions.
+func (c *CPU) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cpuState{
+		CPURegisters: c.DumpRegistersOnly(),
+		ProgramStart: c.programStart,
+		ProgramSize:  c.programSize,
+		Memory:       c.usedMemory(),
+	})
+}
+
+// UnmarshalJSON restores what MarshalJSON saved, memory not in the saved
+// blocks is cleared. Run then continues where the saved CPU left off. The
+// configuration is kept, as with Reset.
+func (c *CPU) UnmarshalJSON(data []byte) error {
+	var s cpuState
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	if s.ProgramStart < 0 || s.ProgramSize < 0 || s.ProgramStart+s.ProgramSize > len(c.Memory) {
+		return fmt.Errorf("program of %d bytes at %05X does not fit in memory", s.ProgramSize, s.ProgramStart)
+	}
+	for _, b := range s.Memory {
+		if b.Addr < 0 || b.Addr+len(b.Data) > len(c.Memory) {
+			return fmt.Errorf("memory block of %d bytes at %05X does not fit in memory", len(b.Data), b.Addr)
+		}
+	}
+
+	c.RestoreRegisters(s.CPURegisters)
+	c.Memory = [len(c.Memory)]byte{}
+	for _, b := range s.Memory {
+		copy(c.Memory[b.Addr:], b.Data)
+	}
+	c.programStart = s.ProgramStart
+	c.programSize = s.ProgramSize
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// sumProgram adds 100 down to 1 into AX through a subroutine, keeping the
+// counter on the stack and the running sum at 0200.
+var sumProgram = []byte{
+	0xB9, 0x64, 0x00, // mov cx, 100
+	0x51,             // 0103 push cx
+	0xE8, 0x04, 0x00, // call 010B
+	0x59,       // pop cx
+	0xE2, 0xF9, // loop 0103
+	hlt,
+	0x01, 0xC8, // 010B add ax, cx
+	0xA3, 0x00, 0x02, // mov [0200h], ax
+	0xC3, // ret
+}
+
+func TestJSONResume(t *testing.T) {
+	whole := newTestCPU(t, sumProgram...)
+	err := whole.Run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMem(t, whole, 0x0200, 2, 5050)
+
+	// 5 is inside the subroutine, with the counter and the return address
+	// on the stack
+	for _, stop := range []int{1, 2, 5, 123, 599} {
+		first := newTestCPU(t, sumProgram...)
+		var hit *MaxInstructionsExceeded
+		err = first.Run(stop)
+		if !errors.As(err, &hit) {
+			t.Fatalf("Run(%d) = %v, want MaxInstructionsExceeded", stop, err)
+		}
+		data, err := json.Marshal(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resumed := NewCPU()
+		err = json.Unmarshal(data, resumed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed.DumpState() != first.DumpState() {
+			t.Fatalf("after %d instructions: unmarshalled CPU differs from the saved one", stop)
+		}
+		err = resumed.Run(0)
+		if err != nil {
+			t.Fatalf("after %d instructions: resumed Run = %v", stop, err)
+		}
+		if resumed.DumpState() != whole.DumpState() {
+			t.Errorf("after %d instructions: resumed run ended with %+v, want %+v", stop, resumed.DumpRegistersOnly(), whole.DumpRegistersOnly())
+		}
+	}
+}
+
+func TestUnmarshalJSONClearsMemory(t *testing.T) {
+	saved := newTestCPU(t, sumProgram...)
+	data, err := json.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCPU()
+	c.Memory[0x50000] = 0x99
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DumpState() != saved.DumpState() {
+		t.Error("memory the saved CPU did not have survived Unmarshal")
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not JSON", `{`},
+		{"program past the end of memory", `{"ProgramStart": 1048575, "ProgramSize": 2}`},
+		{"negative program start", `{"ProgramStart": -1, "ProgramSize": 1}`},
+		{"block past the end of memory", `{"Memory": [{"Addr": 256, "Data": "kA=="}, {"Addr": 1048575, "Data": "kJA="}]}`},
+		{"negative block address", `{"Memory": [{"Addr": -1, "Data": "kA=="}]}`},
+	}
+
+	for _, tt := range tests {
+		c := NewCPU()
+		c.AX = 0x1234
+		err := json.Unmarshal([]byte(tt.data), c)
+		if err == nil {
+			t.Errorf("%s: Unmarshal did not fail", tt.name)
+		}
+		if c.AX != 0x1234 || c.Memory[0x0100] != 0 {
+			t.Errorf("%s: a failed Unmarshal changed the CPU", tt.name)
+		}
+	}
+}
+
+func TestDumpState(t *testing.T) {
+	c := newTestCPU(t, sumProgram...)
+	err := c.Run(50)
+	var hit *MaxInstructionsExceeded
+	if !errors.As(err, &hit) {
+		t.Fatalf("Run = %v, want MaxInstructionsExceeded", err)
+	}
+	saved := c.DumpState()
+
+	err = c.Run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := c.DumpState()
+
+	c.RestoreState(saved)
+	if c.DumpState() != saved {
+		t.Fatal("RestoreState did not restore the dumped state")
+	}
+	err = c.Run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DumpState() != done {
+		t.Error("running again from the restored state ended differently")
+	}
+}
+
+func TestRestoreRegisters(t *testing.T) {
+	c := newTestCPU(t, sumProgram...)
+	err := c.Run(50)
+	var hit *MaxInstructionsExceeded
+	if !errors.As(err, &hit) {
+		t.Fatalf("Run = %v, want MaxInstructionsExceeded", err)
+	}
+	saved := c.DumpRegistersOnly()
+
+	c.Memory[0x0300] = 0x99
+	c.AX, c.FL, c.Instructions = 0, 0xFFFF, 0
+	c.RestoreRegisters(saved)
+	if got := c.DumpRegistersOnly(); got != saved {
+		t.Errorf("RestoreRegisters = %+v, want %+v", got, saved)
+	}
+	if c.Memory[0x0300] != 0x99 {
+		t.Error("RestoreRegisters changed memory")
+	}
+
+	saved.FL = 0x0000
+	c.RestoreRegisters(saved)
+	if c.FL != flagsFixed {
+		t.Errorf("FL = %04X, want the fixed bits %04X", c.FL, flagsFixed)
+	}
+}
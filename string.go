@@ -0,0 +1,78 @@
+package main
+
+// stringCycles holds the clocks of a single, not repeated, string
+// instruction, indexed by its byte form opcode.
+var stringCycles = map[uint8]uint8{
+	0xA4: 18, // MOVS
+	0xA6: 22, // CMPS
+	0xAA: 11, // STOS
+	0xAC: 12, // LODS
+	0xAE: 15, // SCAS
+}
+
+// repStringCycles holds the clocks of one repetition of a string instruction
+// with a repeat prefix, indexed like stringCycles.
+var repStringCycles = map[uint8]uint8{
+	0xA4: 17, // MOVS
+	0xA6: 22, // CMPS
+	0xAA: 10, // STOS
+	0xAC: 13, // LODS
+	0xAE: 15, // SCAS
+}
+
+// siDiStep returns how much SI and DI move after a string operation of the
+// given width: forward when DF is clear, backward when it is set.
+func siDiStep(c *CPU, width uint8) int16 {
+	if c.GetFlag(FlagDF) {
+		return -int16(width)
+	}
+	return int16(width)
+}
+
+// execString runs a string instruction. With a repeat prefix it runs CX
+// times, CMPS and SCAS also stop as soon as ZF no longer matches the prefix.
+// Counting CX down does not touch the flags.
+func (c *CPU) execString(inst Instruction) {
+	if inst.REP == 0 {
+		c.stringStep(inst)
+		return
+	}
+
+	compare := inst.Mnemonic[:4] == "CMPS" || inst.Mnemonic[:4] == "SCAS"
+	for c.CX != 0 {
+		c.stringStep(inst)
+		c.CX--
+		c.Cycles += uint64(repStringCycles[inst.Opcode&^1])
+
+		if compare && c.GetFlag(FlagZF) != (inst.REP == prefixREPE) {
+			return
+		}
+	}
+}
+
+// stringStep runs one iteration of a string instruction. The source is
+// DS:SI, or SI in the override segment, and the destination is always ES:DI.
+func (c *CPU) stringStep(inst Instruction) {
+	step := uint16(siDiStep(c, inst.Width))
+	srcSeg := c.dataSegment()
+
+	switch inst.Mnemonic {
+	case "MOVSB", "MOVSW":
+		c.writeMem(c.ES, c.DI, inst.Width, c.readMem(srcSeg, c.SI, inst.Width))
+		c.SI += step
+		c.DI += step
+	case "CMPSB", "CMPSW":
+		c.sub(c.readMem(srcSeg, c.SI, inst.Width), c.readMem(c.ES, c.DI, inst.Width), 0, inst.Width)
+		c.SI += step
+		c.DI += step
+	case "SCASB", "SCASW":
+		c.sub(c.readOperand(inst, accumulator), c.readMem(c.ES, c.DI, inst.Width), 0, inst.Width)
+		c.DI += step
+	case "LODSB", "LODSW":
+		c.writeOperand(inst, accumulator, c.readMem(srcSeg, c.SI, inst.Width))
+		c.SI += step
+	case "STOSB", "STOSW":
+		c.writeMem(c.ES, c.DI, inst.Width, c.readOperand(inst, accumulator))
+		c.DI += step
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "MOVSB", code: []byte{0xA4, hlt},
+			setup: func(c *CPU) {
+				c.SI, c.DI, c.ES = 0x0200, 0x0010, 0x1000
+				c.Memory[0x0200] = 0x42
+			},
+			regs:  map[string]uint16{"SI": 0x0201, "DI": 0x0011},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x10010, 1, 0x42) },
+		},
+		{
+			name: "MOVSW backward", code: []byte{0xFD, 0xA5, hlt},
+			setup: func(c *CPU) {
+				c.SI, c.DI = 0x0200, 0x0300
+				c.WriteMemWord(0, 0x0200, 0xBEEF)
+			},
+			regs:  map[string]uint16{"SI": 0x01FE, "DI": 0x02FE},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0300, 2, 0xBEEF) },
+		},
+		{
+			name: "STOSB", code: []byte{0xAA, hlt},
+			setup: func(c *CPU) { c.AX, c.DI = 0x1234, 0x0200 },
+			regs:  map[string]uint16{"DI": 0x0201},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x0034) },
+		},
+		{
+			name: "STD, STOSW", code: []byte{0xFD, 0xAB, hlt},
+			setup: func(c *CPU) { c.AX, c.DI = 0x1234, 0x0200 },
+			regs:  map[string]uint16{"DI": 0x01FE},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 2, 0x1234) },
+		},
+		{
+			name: "LODSW", code: []byte{0xAD, hlt},
+			setup: func(c *CPU) { c.SI = 0x0200; c.WriteMemWord(0, 0x0200, 0xBEEF) },
+			regs:  map[string]uint16{"AX": 0xBEEF, "SI": 0x0202},
+		},
+		{
+			name: "LODSB from ES", code: []byte{0x26, 0xAC, hlt},
+			setup: func(c *CPU) {
+				c.SI, c.ES = 0x0200, 0x2000
+				c.Memory[0x0200] = 0x11
+				c.Memory[0x20200] = 0x22
+			},
+			regs: map[string]uint16{"AX": 0x0022, "SI": 0x0201},
+		},
+		{
+			name: "MOVSB from CS", code: []byte{0x2E, 0xA4, hlt},
+			setup: func(c *CPU) {
+				c.SI, c.DI, c.CS, c.DS = 0x0000, 0x0400, 0x0010, 0x3000
+				c.IP = 0x0000 // the code at 0000:0100 is 0010:0000
+			},
+			regs:  map[string]uint16{"SI": 0x0001, "DI": 0x0401},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0400, 1, 0x2E) },
+		},
+		{
+			name: "SCASB equal", code: []byte{0xAE, hlt},
+			setup: func(c *CPU) { c.AX, c.DI = 0x0042, 0x0200; c.Memory[0x0200] = 0x42 },
+			regs:  map[string]uint16{"DI": 0x0201},
+			set:   FlagZF,
+		},
+		{
+			name: "SCASB below", code: []byte{0xAE, hlt},
+			setup: func(c *CPU) { c.AX, c.DI = 0x0041, 0x0200; c.Memory[0x0200] = 0x42 },
+			set:   FlagCF | FlagSF, clear: FlagZF,
+		},
+		{
+			name: "CMPSW", code: []byte{0xA7, hlt},
+			setup: func(c *CPU) {
+				c.SI, c.DI = 0x0200, 0x0300
+				c.WriteMemWord(0, 0x0200, 0x0001)
+				c.WriteMemWord(0, 0x0300, 0x0002)
+			},
+			regs: map[string]uint16{"SI": 0x0202, "DI": 0x0302},
+			set:  FlagCF | FlagSF, clear: FlagZF,
+		},
+	})
+}
+
+func TestRepString(t *testing.T) {
+	runProgramTests(t, []programTest{
+		{
+			name: "REP MOVSB 256 bytes", code: []byte{0xF3, 0xA4, hlt},
+			setup: func(c *CPU) {
+				c.CX, c.SI, c.DI, c.ES = 256, 0x0200, 0x0000, 0x2000
+				for i := 0; i < 256; i++ {
+					c.Memory[0x0200+i] = byte(i)
+				}
+			},
+			regs: map[string]uint16{"CX": 0x0000, "SI": 0x0300, "DI": 0x0100},
+			check: func(t *testing.T, c *CPU) {
+				if !bytes.Equal(c.Memory[0x20000:0x20100], c.Memory[0x0200:0x0300]) {
+					t.Error("REP MOVSB did not copy the block")
+				}
+			},
+		},
+		{
+			name: "REP STOSW", code: []byte{0xF3, 0xAB, hlt},
+			setup: func(c *CPU) { c.AX, c.CX, c.DI = 0xBEEF, 3, 0x0200 },
+			regs:  map[string]uint16{"CX": 0x0000, "DI": 0x0206},
+			check: func(t *testing.T, c *CPU) {
+				wantMem(t, c, 0x0204, 2, 0xBEEF)
+				wantMem(t, c, 0x0206, 2, 0x0000)
+			},
+		},
+		{
+			name: "REP with CX=0 does nothing", code: []byte{0xF3, 0xAA, hlt},
+			setup: func(c *CPU) { c.AX, c.DI = 0x0042, 0x0200 },
+			regs:  map[string]uint16{"CX": 0x0000, "DI": 0x0200},
+			check: func(t *testing.T, c *CPU) { wantMem(t, c, 0x0200, 1, 0x00) },
+		},
+		{
+			name: "REPNE SCASB finds the terminator", code: []byte{0xF2, 0xAE, hlt},
+			setup: func(c *CPU) {
+				c.CX, c.DI = 0xFFFF, 0x0200
+				copy(c.Memory[0x0200:], "hello\x00")
+			},
+			regs: map[string]uint16{"CX": 0xFFF9, "DI": 0x0206}, // 6 bytes scanned
+			set:  FlagZF,
+		},
+		{
+			name: "REPNE SCASB runs out", code: []byte{0xF2, 0xAE, hlt},
+			setup: func(c *CPU) {
+				c.CX, c.DI = 3, 0x0200
+				copy(c.Memory[0x0200:], "hello\x00")
+			},
+			regs:  map[string]uint16{"CX": 0x0000, "DI": 0x0203},
+			clear: FlagZF,
+		},
+		{
+			name: "REPE CMPSB finds the mismatch", code: []byte{0xF3, 0xA6, hlt},
+			setup: func(c *CPU) {
+				c.CX, c.SI, c.DI = 8, 0x0200, 0x0300
+				copy(c.Memory[0x0200:], "abcdefgh")
+				copy(c.Memory[0x0300:], "abcXefgh")
+			},
+			regs:  map[string]uint16{"CX": 0x0004, "SI": 0x0204, "DI": 0x0304},
+			clear: FlagZF,
+		},
+		{
+			name: "REPE CMPSB equal strings", code: []byte{0xF3, 0xA6, hlt},
+			setup: func(c *CPU) {
+				c.CX, c.SI, c.DI = 8, 0x0200, 0x0300
+				copy(c.Memory[0x0200:], "abcdefgh")
+				copy(c.Memory[0x0300:], "abcdefgh")
+			},
+			regs: map[string]uint16{"CX": 0x0000, "SI": 0x0208, "DI": 0x0308},
+			set:  FlagZF,
+		},
+		{
+			name: "STD, REP MOVSB overlapping", code: []byte{0xFD, 0xF3, 0xA4, hlt},
+			setup: func(c *CPU) {
+				c.CX, c.SI, c.DI = 4, 0x0203, 0x0204
+				copy(c.Memory[0x0200:], "abcd")
+			},
+			regs: map[string]uint16{"CX": 0x0000, "SI": 0x01FF, "DI": 0x0200},
+			check: func(t *testing.T, c *CPU) {
+				if got := string(c.Memory[0x0200:0x0205]); got != "aabcd" {
+					t.Errorf("memory = %q, want %q", got, "aabcd")
+				}
+			},
+		},
+	})
+}
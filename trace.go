@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// traceRegisters are the registers a trace line reports changes of, IP is
+// left out as it is already the first field.
+var traceRegisters = []struct {
+	name string
+	reg  func(c *CPU) *uint16
+}{
+	{"AX", func(c *CPU) *uint16 { return &c.AX }},
+	{"BX", func(c *CPU) *uint16 { return &c.BX }},
+	{"CX", func(c *CPU) *uint16 { return &c.CX }},
+	{"DX", func(c *CPU) *uint16 { return &c.DX }},
+	{"SI", func(c *CPU) *uint16 { return &c.SI }},
+	{"DI", func(c *CPU) *uint16 { return &c.DI }},
+	{"BP", func(c *CPU) *uint16 { return &c.BP }},
+	{"SP", func(c *CPU) *uint16 { return &c.SP }},
+	{"CS", func(c *CPU) *uint16 { return &c.CS }},
+	{"DS", func(c *CPU) *uint16 { return &c.DS }},
+	{"ES", func(c *CPU) *uint16 { return &c.ES }},
+	{"SS", func(c *CPU) *uint16 { return &c.SS }},
+	{"FL", func(c *CPU) *uint16 { return &c.FL }},
+}
+
+// EnableTrace makes Step, and so Run, write a line to w for every
+// instruction executed. The fields are separated by tabs: CS:IP, the bytes
+// of the instruction, the instruction and the registers it changed, e.g.
+//
+//	0000:0100	B8 34 12	MOV AX, 0x1234	AX=1234
+func (c *CPU) EnableTrace(w io.Writer) {
+	c.trace = w
+}
+
+// DisableTrace stops the trace started by EnableTrace.
+func (c *CPU) DisableTrace() {
+	c.trace = nil
+}
+
+// traceState holds what a trace line needs from before the instruction ran.
+type traceState struct {
+	cs, ip uint16
+	raw    []byte
+	regs   []uint16
+}
+
+// traceBefore records the state of the CPU before inst runs.
+func (c *CPU) traceBefore(inst Instruction) traceState {
+	s := traceState{cs: c.CS, ip: c.IP}
+	for i := uint16(0); i < uint16(inst.Size); i++ {
+		s.raw = append(s.raw, c.Memory[physicalAddress(c.CS, c.IP+i)])
+	}
+	for _, r := range traceRegisters {
+		s.regs = append(s.regs, *r.reg(c))
+	}
+	return s
+}
+
+// traceAfter writes the trace line of inst, s is what traceBefore returned.
+func (c *CPU) traceAfter(inst Instruction, s traceState) {
+	raw := make([]string, len(s.raw))
+	for i, b := range s.raw {
+		raw[i] = fmt.Sprintf("%02X", b)
+	}
+
+	var changed []string
+	for i, r := range traceRegisters {
+		if v := *r.reg(c); v != s.regs[i] {
+			changed = append(changed, fmt.Sprintf("%s=%04X", r.name, v))
+		}
+	}
+
+	fmt.Fprintf(c.trace, "%04X:%04X\t%s\t%s\t%s\n", s.cs, s.ip,
+		strings.Join(raw, " "), formatInstruction(inst, s.ip), strings.Join(changed, " "))
+}
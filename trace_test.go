@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	c := newTestCPU(t,
+		0xB8, 0x34, 0x12, // mov ax, 1234h
+		0xFE, 0xC2, // inc dl
+		0xF4, // hlt
+	)
+	var out strings.Builder
+	c.EnableTrace(&out)
+
+	err := c.Run(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "0000:0100\tB8 34 12\tMOV AX, 0x1234\tAX=1234\n" +
+		"0000:0103\tFE C2\tINC DL\tDX=0001\n" +
+		"0000:0105\tF4\tHLT\t\n"
+	if out.String() != want {
+		t.Errorf("trace:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	c.DisableTrace()
+	c.ResetRegisters()
+	out.Reset()
+	_ = c.Run(0)
+	if out.Len() != 0 {
+		t.Errorf("DisableTrace left the trace on: %q", out.String())
+	}
+}